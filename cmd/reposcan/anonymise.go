@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Anonymiser replaces contributor logins with stable pseudonyms such as
+// contributor-001. A nil Anonymiser leaves logins untouched.
+type Anonymiser struct {
+	path  string
+	names map[string]string
+}
+
+// newAnonymiser creates an anonymiser. If path is not empty, the mapping
+// is loaded from (and later saved to) the file so pseudonyms remain
+// consistent between runs.
+func newAnonymiser(path string) (*Anonymiser, error) {
+	a := &Anonymiser{
+		path:  path,
+		names: make(map[string]string),
+	}
+	if path == "" {
+		return a, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return a, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot read anonymise mapping: %w", err)
+	}
+	err = json.Unmarshal(data, &a.names)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse anonymise mapping: %w", err)
+	}
+	return a, nil
+}
+
+// Assign allocates pseudonyms for all the logins not yet mapped. Logins
+// are allocated in sorted order so the mapping does not depend on map
+// iteration order.
+func (a *Anonymiser) Assign(users map[string]User) {
+	if a == nil {
+		return
+	}
+	logins := make([]string, 0, len(users))
+	for login := range users {
+		logins = append(logins, login)
+	}
+	sort.Strings(logins)
+	for _, login := range logins {
+		a.Name(login)
+	}
+}
+
+// Name returns the pseudonym for a login, allocating a new one if the
+// login has not been seen before.
+func (a *Anonymiser) Name(login string) string {
+	if a == nil || login == othersLogin {
+		return login
+	}
+	if name, ok := a.names[login]; ok {
+		return name
+	}
+	name := fmt.Sprintf("contributor-%03d", len(a.names)+1)
+	a.names[login] = name
+	return name
+}
+
+// Save persists the mapping if a mapping file was supplied.
+func (a *Anonymiser) Save() error {
+	if a == nil || a.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(a.names, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(a.path, data, 0600)
+	if err != nil {
+		return fmt.Errorf("cannot write anonymise mapping: %w", err)
+	}
+	return nil
+}
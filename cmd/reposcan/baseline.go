@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Tolerance bounds the change of a metric relative to its value in the
+// baseline, as a fraction of the baseline value. Either bound may be
+// omitted.
+type Tolerance struct {
+	MaxDrop *float64 `json:"max_drop"`
+	MaxRise *float64 `json:"max_rise"`
+}
+
+// validateBaseline checks that the tolerances only reference known pulse
+// or custom metrics, and are not negative.
+func validateBaseline(config Config) error {
+	known := pulseMetricValues(Pulse{})
+	for name, t := range config.Baseline {
+		_, builtin := known[name]
+		_, custom := config.Settings.CustomMetrics[name]
+		if !builtin && !custom {
+			return fmt.Errorf("baseline tolerance on unknown metric %q", name)
+		}
+		if (t.MaxDrop != nil && *t.MaxDrop < 0) || (t.MaxRise != nil && *t.MaxRise < 0) {
+			return fmt.Errorf("baseline tolerance on %q must not be negative", name)
+		}
+	}
+	if *baseline != "" && len(config.Baseline) == 0 {
+		return errors.New("a baseline file requires baseline tolerances")
+	}
+	return nil
+}
+
+// latestComplete returns the index of the latest complete pulse, or -1
+// if no pulse is complete.
+func latestComplete(pulses []Pulse) int {
+	for i := len(pulses) - 1; i >= 0; i-- {
+		if !pulses[i].Partial {
+			return i
+		}
+	}
+	return -1
+}
+
+// latestMetrics returns the built-in and custom metrics of the latest
+// complete pulse, or nil if no pulse is complete.
+func latestMetrics(custom []CustomMetric, pulses []Pulse) map[string]float64 {
+	i := latestComplete(pulses)
+	if i < 0 {
+		return nil
+	}
+	builtin := pulseMetricValues(pulses[i])
+	values := pulseMetricValues(pulses[i])
+	for _, m := range custom {
+		values[m.Name] = m.Expr.Eval(builtin)
+	}
+	return values
+}
+
+// checkBaseline compares the latest complete pulse of each repo with the
+// baseline, and returns a description of every change beyond the
+// tolerances. Repos or metrics missing from the baseline are skipped.
+func checkBaseline(config Config, base *Summary, cur *Summary) []string {
+	names := make([]string, 0, len(config.Baseline))
+	for name := range config.Baseline {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	regressions := make([]string, 0)
+	for _, k := range config.Repos {
+		b, ok := base.Repos[k]
+		if !ok || b.Metrics == nil {
+			fmt.Printf("%s: no baseline metrics to compare against\n", k)
+			continue
+		}
+		c := cur.Repos[k].Metrics
+		if c == nil {
+			continue
+		}
+
+		for _, name := range names {
+			t := config.Baseline[name]
+			was, ok := b.Metrics[name]
+			if !ok {
+				continue
+			}
+			v, ok := c[name]
+			if !ok {
+				continue
+			}
+			if t.MaxDrop != nil && v < was*(1-*t.MaxDrop) {
+				regressions = append(regressions, fmt.Sprintf("%s: %s dropped from %s to %s, more than %g%%", k, name, formatFloat(config, was), formatFloat(config, v), *t.MaxDrop*100))
+			}
+			if t.MaxRise != nil && v > was*(1+*t.MaxRise) {
+				regressions = append(regressions, fmt.Sprintf("%s: %s rose from %s to %s, more than %g%%", k, name, formatFloat(config, was), formatFloat(config, v), *t.MaxRise*100))
+			}
+		}
+	}
+	return regressions
+}
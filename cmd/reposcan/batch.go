@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/shurcooL/githubv4"
+)
+
+// batchPage is the first page of the PR history of a repo read in a
+// batch, with the share of the batch request of the repo.
+type batchPage struct {
+	entry *RepoEntry
+	stats FetchStats
+}
+
+// repoFirstPages requests the first page of the PR history of several
+// repos in a single request, by aliasing a repository query per repo.
+// This saves a round trip per repo, which dominates the scan time of
+// orgs with many small repos. The cost and duration of the request are
+// split evenly over the repos returned, each counting the request as a
+// page. If the request fails, its duration is added to stats.
+func repoFirstPages(ctx context.Context, client *githubv4.Client, config Config, keys []string, stats *FetchStats) (map[string]*batchPage, error) {
+	states, err := prStates(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// The query type is built at runtime, with a field per repo
+	// sharing the type of RepoEntry.Repository.
+	entry := reflect.TypeOf(RepoEntry{})
+	repository, _ := entry.FieldByName("Repository")
+	rateLimit, _ := entry.FieldByName("RateLimit")
+
+	variables := prEntryVariables(config)
+	variables["nodesCursor"] = (*githubv4.String)(nil)
+	variables["states"] = states
+	variables["orderBy"] = prOrder(config)
+	fields := make([]reflect.StructField, 0, len(keys)+1)
+	for i, k := range keys {
+		org, repo, err := orgRepoSplit(k)
+		if err != nil {
+			return nil, err
+		}
+		variables[fmt.Sprintf("owner%d", i)] = githubv4.String(org)
+		variables[fmt.Sprintf("name%d", i)] = githubv4.String(repo)
+		fields = append(fields, reflect.StructField{
+			Name: fmt.Sprintf("Repo%d", i),
+			Type: repository.Type,
+			Tag:  reflect.StructTag(fmt.Sprintf(`graphql:"repo%d: repository(owner: $owner%d, name: $name%d)"`, i, i, i)),
+		})
+	}
+	fields = append(fields, reflect.StructField{
+		Name: "RateLimit",
+		Type: rateLimit.Type,
+	})
+
+	begin := time.Now()
+	q := reflect.New(reflect.StructOf(fields))
+	err = client.Query(ctx, q.Interface(), variables)
+	duration := time.Since(begin)
+	msgs := graphqlErrors(err)
+	if err != nil && msgs == nil {
+		stats.Duration += duration
+		return nil, fmt.Errorf("batched repo request failed: %w", err)
+	}
+	cost := int(q.Elem().FieldByName("RateLimit").FieldByName("Cost").Int())
+
+	// With a partial response, only the repos with data are used, and
+	// the others are left to be read one by one.
+	pages := make(map[string]*batchPage, len(keys))
+	returned := make([]string, 0, len(keys))
+	for i, k := range keys {
+		page := &RepoEntry{}
+		reflect.ValueOf(&page.Repository).Elem().Set(q.Elem().Field(i))
+		if msgs != nil && page.Repository.CreatedAt.IsZero() {
+			continue
+		}
+		pages[k] = &batchPage{entry: page}
+		returned = append(returned, k)
+	}
+	if msgs != nil {
+		if len(pages) == 0 {
+			stats.Duration += duration
+			stats.Cost += cost
+			return nil, fmt.Errorf("batched repo request failed: %w", err)
+		}
+		logPartial(keys[0], msgs)
+	}
+
+	// The remainder of the cost goes to the first repos, so the shares
+	// add up to the cost of the request
+	n := len(returned)
+	for i, k := range returned {
+		s := &pages[k].stats
+		s.Pages = 1
+		s.Cost = cost / n
+		if i < cost%n {
+			s.Cost += 1
+		}
+		s.Duration = duration / time.Duration(n)
+	}
+	return pages, nil
+}
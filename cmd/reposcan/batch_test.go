@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shurcooL/githubv4"
+)
+
+func TestBatchStatsSplit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data": {
+			"repo0": {"createdAt": "2024-01-01T00:00:00Z"},
+			"repo1": {"createdAt": "2024-01-02T00:00:00Z"},
+			"repo2": {"createdAt": "2024-01-03T00:00:00Z"},
+			"rateLimit": {"cost": 5}
+		}}`)
+	}))
+	defer srv.Close()
+
+	config := testConfig()
+	config.Repos = []string{"org/a", "org/b", "org/c"}
+	config.Settings.Fetch.BatchSize = 3
+	g := &graphqlSource{
+		client: githubv4.NewEnterpriseClient(srv.URL, srv.Client()),
+		first:  make(map[string]*batchPage),
+	}
+
+	var total FetchStats
+	costs := make([]int, 0)
+	for _, k := range config.Repos {
+		var stats FetchStats
+		if page := g.firstPage(context.Background(), config, k, &stats); page == nil {
+			t.Fatalf("%s: no first page", k)
+		}
+		if stats.Pages != 1 {
+			t.Errorf("%s: %d pages, want 1", k, stats.Pages)
+		}
+		costs = append(costs, stats.Cost)
+		total.add(stats)
+	}
+	if fmt.Sprint(costs) != "[2 2 1]" {
+		t.Errorf("costs %v, want [2 2 1]", costs)
+	}
+	if total.Cost != 5 {
+		t.Errorf("total cost %d, want 5", total.Cost)
+	}
+}
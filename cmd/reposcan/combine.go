@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+const (
+	combinedSummaryFile = "combined-summary.json"
+	combinedCompareFile = "combined-compare.csv"
+)
+
+// runCombine merges the summaries of the runs in dirs into a single
+// summary and comparison, without reading anything from Github. A repo
+// scanned by more than one run is taken from the last of them.
+func runCombine(dirs []string) error {
+	fmt.Printf("reposcan v%s combine\n", version)
+
+	if len(dirs) == 0 {
+		err := errors.New("no run directories")
+		fmt.Println("Error combining runs:", err)
+		return err
+	}
+
+	combined := &Summary{
+		Repos: make(map[string]RepoSummary),
+	}
+	keys := make([]string, 0)
+	runs := make(map[string]*Summary)
+	for _, dir := range dirs {
+		name := filepath.Join(dir, summaryFile)
+		fmt.Printf("loading %s...\n", name)
+		s, err := loadSummary(name)
+		if err != nil {
+			fmt.Println("Error loading summary:", err)
+			return err
+		}
+		if s == nil {
+			err = fmt.Errorf("%s not found", name)
+			fmt.Println("Error loading summary:", err)
+			return err
+		}
+
+		repos := make([]string, 0, len(s.Repos))
+		for k := range s.Repos {
+			repos = append(repos, k)
+		}
+		sort.Strings(repos)
+		for _, k := range repos {
+			if _, ok := combined.Repos[k]; ok {
+				fmt.Printf("%s: warning: repo is in more than one run, using the run in %s\n", k, dir)
+			} else {
+				keys = append(keys, k)
+			}
+			combined.Repos[k] = s.Repos[k]
+			runs[k] = s
+		}
+		if s.Time.After(combined.Time) {
+			combined.Time = s.Time
+		}
+	}
+
+	fmt.Printf("generating combined comparison...\n")
+	err := writeFile(combinedCompareFile, "combined comparison", func(out io.Writer) error {
+		return writeCombined(out, keys, combined, runs)
+	})
+	if err != nil {
+		fmt.Println("Error writing combined comparison:", err)
+		return err
+	}
+
+	// The summaries of the runs were anonymised when saved, if at all
+	err = saveSummary(combinedSummaryFile, "combined summary", combined, nil)
+	if err != nil {
+		fmt.Println("Error saving combined summary:", err)
+		return err
+	}
+
+	fmt.Println("done.")
+	return nil
+}
+
+// writeCombined writes a row per repo to out, with the time of the run
+// it was taken from, its PR and contributor counts, and the metrics of
+// its latest complete pulse. The metric columns are the built-in metrics
+// followed by any other metrics present in the runs, such as custom
+// metrics, by name.
+func writeCombined(out io.Writer, keys []string, combined *Summary, runs map[string]*Summary) error {
+	names := make([]string, 0, len(pulseMetrics))
+	known := make(map[string]bool)
+	for _, m := range pulseMetrics {
+		names = append(names, m.Name)
+		known[m.Name] = true
+	}
+	extra := make([]string, 0)
+	for _, k := range keys {
+		for name := range combined.Repos[k].Metrics {
+			if !known[name] {
+				extra = append(extra, name)
+				known[name] = true
+			}
+		}
+	}
+	sort.Strings(extra)
+	names = append(names, extra...)
+
+	w := csv.NewWriter(out)
+	w.Write(append([]string{"Repo", "Run", "Merged", "Open", "Contributors"}, names...))
+	for _, k := range keys {
+		r := combined.Repos[k]
+		line := []string{
+			k,
+			runs[k].Time.Format("2006-01-02 15:04"),
+			strconv.Itoa(r.Merged),
+			strconv.Itoa(r.Open),
+			strconv.Itoa(len(r.Contributors)),
+		}
+		for _, name := range names {
+			// No value if the run has no metrics for the repo
+			v, ok := r.Metrics[name]
+			if !ok {
+				line = append(line, "")
+				continue
+			}
+			// The runs may use different precisions, so the default is used
+			line = append(line, formatFloat(Config{}, v))
+		}
+		w.Write(line)
+	}
+	w.Flush()
+	return w.Error()
+}
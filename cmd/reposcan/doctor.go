@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/shurcooL/githubv4"
+	"golang.org/x/oauth2"
+)
+
+// doctorCheck is a single check of the doctor report.
+type doctorCheck struct {
+	name string
+	// The check does not depend on the checks before it
+	standalone bool
+	run        func() (string, error)
+}
+
+// runDoctor checks the setup needed for a scan, and prints a pass or
+// fail line for each check. Checks depending on a failed check are
+// skipped.
+func runDoctor() error {
+	fmt.Printf("reposcan v%s doctor\n", version)
+
+	ctx := context.Background()
+	var token string
+	var config Config
+	var client *githubv4.Client
+	var rest *restSource
+
+	checks := []doctorCheck{{
+		name: "token file",
+		run: func() (string, error) {
+			data, err := os.ReadFile(".token")
+			if err != nil {
+				return "", err
+			}
+			token = strings.Trim(string(data), "\n\r")
+			if token == "" {
+				return "", fmt.Errorf(".token is empty")
+			}
+			return "found .token", nil
+		},
+	}, {
+		name:       "config",
+		standalone: true,
+		run: func() (string, error) {
+			data, err := os.ReadFile("config.json")
+			if err != nil {
+				return "", err
+			}
+			err = json.Unmarshal(data, &config)
+			if err != nil {
+				return "", err
+			}
+			overrideSettings(&config)
+			err = readReposFile(&config)
+			if err != nil {
+				return "", err
+			}
+			err = validateConfig(config)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d repos, %d searches", len(config.Repos), len(config.Searches)), nil
+		},
+	}, {
+		name: "authentication",
+		run: func() (string, error) {
+			ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+			tc := oauth2.NewClient(ctx, ts)
+
+			if config.Settings.Fetch.APIMode == "rest" {
+				rest = &restSource{client: tc, baseURL: apiURL(config)}
+				var viewer restUser
+				_, err := rest.get(ctx, rest.baseURL+"/user", &viewer)
+				if err != nil {
+					return "", err
+				}
+				return "authenticated as " + viewer.Login, nil
+			}
+
+			client = newGraphQLClient(config, tc)
+			var q struct {
+				Viewer struct {
+					Login string
+				}
+			}
+			err := client.Query(ctx, &q, nil)
+			if err != nil {
+				return "", err
+			}
+			return "authenticated as " + q.Viewer.Login, nil
+		},
+	}, {
+		name: "repo access",
+		run: func() (string, error) {
+			if len(config.Repos) == 0 {
+				return "no repos configured", nil
+			}
+			org, repo, err := orgRepoSplit(config.Repos[0])
+			if err != nil {
+				return "", err
+			}
+			var owner string
+			if rest != nil {
+				var info restRepo
+				_, err = rest.get(ctx, fmt.Sprintf("%s/repos/%s/%s", rest.baseURL, org, repo), &info)
+				owner = info.Owner.Type
+			} else {
+				var info RepoInfoEntry
+				info, err = repoInfo(ctx, client, org, repo)
+				owner = info.Repository.Owner.Typename
+			}
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s is reachable, owned by %s", config.Repos[0], ownerDesc(owner)), nil
+		},
+	}, {
+		name:       "output directory",
+		standalone: true,
+		run: func() (string, error) {
+			f, err := os.CreateTemp(".", ".reposcan-doctor-*")
+			if err != nil {
+				return "", err
+			}
+			f.Close()
+			return "current directory is writable", os.Remove(f.Name())
+		},
+	}}
+
+	failed := false
+	for _, c := range checks {
+		if failed && !c.standalone {
+			fmt.Printf("[SKIP] %s\n", c.name)
+			continue
+		}
+		detail, err := c.run()
+		if err != nil {
+			fmt.Printf("[FAIL] %s: %v\n", c.name, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("[PASS] %s: %s\n", c.name, detail)
+	}
+
+	if failed {
+		return errors.New("doctor checks failed")
+	}
+	fmt.Println("all checks passed.")
+	return nil
+}
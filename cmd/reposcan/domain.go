@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// unknownDomain groups the PRs without a usable commit email, such as
+// PRs whose author hides their email behind a Github no-reply address.
+const unknownDomain = "(unknown)"
+
+// emailDomain returns the domain of the email of the author of the first
+// commit of a PR.
+func emailDomain(p PrEntry) string {
+	if len(p.FirstCommit.Nodes) == 0 {
+		return unknownDomain
+	}
+	email := p.FirstCommit.Nodes[0].Commit.Author.Email
+	at := strings.LastIndex(email, "@")
+	if at < 0 || at == len(email)-1 {
+		return unknownDomain
+	}
+	domain := strings.ToLower(email[at+1:])
+	if domain == "users.noreply.github.com" {
+		return unknownDomain
+	}
+	return domain
+}
+
+// DomainSummary holds the PRs of all the contributors sharing an email
+// domain.
+type DomainSummary struct {
+	Contributors map[string]bool
+	PRs          int
+	Merged       int
+	Closed       int
+	Open         int
+}
+
+func genDomainSummary(config Config, repos map[string]*Repo) error {
+	return writeFile("domain-summary.csv", "domain summary", func(out io.Writer) error {
+		return writeDomainSummary(out, config, repos)
+	})
+}
+
+// writeDomainSummary writes a row per email domain to out, with the
+// contributors and PRs of the domain over all repos. Each PR counted in
+// the pulses is counted once, in the state of the last pulse counting it.
+func writeDomainSummary(out io.Writer, config Config, repos map[string]*Repo) error {
+	pulls := make(map[string]Pull)
+	for _, k := range config.Repos {
+		if repos[k] == nil {
+			continue
+		}
+		for _, p := range repos[k].pulses {
+			for _, pull := range p.Pulls {
+				// Searches span repos, so the PR number alone
+				// is not unique within a source
+				pulls[fmt.Sprintf("%s %s#%d", k, pull.Repo, pull.Number)] = pull
+			}
+		}
+	}
+
+	domains := make(map[string]*DomainSummary)
+	for _, pull := range pulls {
+		d, ok := domains[pull.Domain]
+		if !ok {
+			d = &DomainSummary{Contributors: make(map[string]bool)}
+			domains[pull.Domain] = d
+		}
+		d.Contributors[pull.Contributor] = true
+		d.PRs += 1
+		switch {
+		case pull.Merged:
+			d.Merged += 1
+		case pull.Open:
+			d.Open += 1
+		case pull.Closed:
+			d.Closed += 1
+		}
+	}
+
+	names := make([]string, 0, len(domains))
+	for name := range domains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := csv.NewWriter(out)
+	w.Write([]string{"Domain", "Contributors", "PRs", "Merged", "Closed", "Open"})
+	for _, name := range names {
+		d := domains[name]
+		w.Write([]string{
+			name,
+			strconv.Itoa(len(d.Contributors)),
+			strconv.Itoa(d.PRs),
+			strconv.Itoa(d.Merged),
+			strconv.Itoa(d.Closed),
+			strconv.Itoa(d.Open),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
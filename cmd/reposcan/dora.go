@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"time"
+)
+
+func genDORA(config Config, repos map[string]*Repo) error {
+	return writeFile("dora.csv", "dora", func(out io.Writer) error {
+		return writeDORA(out, config, repos)
+	})
+}
+
+// writeDORA writes a row per repo to out with proxies of the DORA
+// metrics over all pulses of the graphs, derived from the PRs:
+//
+//   - Deployment frequency: merges to the default branch per week.
+//   - Lead time for changes: median hours from PR creation until merged.
+//   - Change failure rate: merged reverts per merged PR.
+//
+// The time to restore service cannot be derived from PRs, and is left
+// out.
+func writeDORA(out io.Writer, config Config, repos map[string]*Repo) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{
+		"Repo",
+		"Weeks",
+		"Deployment Frequency (Merges per Week)",
+		"Lead Time Hours (Median)",
+		"Change Failure Rate (Reverts per Merge)",
+	})
+	for _, k := range config.Repos {
+		var elapsed time.Duration
+		merged := 0
+		reverts := 0
+		latencies := make([]time.Duration, 0)
+		for _, p := range repos[k].pulses {
+			end := p.End
+			if now.Before(end) {
+				end = now
+			}
+			if end.After(p.Start) {
+				elapsed += end.Sub(p.Start)
+			}
+			// The raw tally, as the pulse values may be prorated
+			if p.tally != nil {
+				merged += int(p.tally.merged)
+				reverts += p.tally.reverts
+				latencies = append(latencies, p.tally.merges...)
+			}
+		}
+		weeks := elapsed.Hours() / 24 / 7
+
+		// No values without a period or merges
+		frequency := ""
+		if weeks > 0 {
+			frequency = formatFloat(config, float64(merged)/weeks)
+		}
+		failures := ""
+		if merged > 0 {
+			failures = formatFloat(config, float64(reverts)/float64(merged))
+		}
+		w.Write([]string{
+			k,
+			formatFloat(config, weeks),
+			frequency,
+			formatFloat(config, float64(medianHours(latencies))),
+			failures,
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// RepoEvent is written as a single JSON line when the pulses of a repo
+// are computed, so consumers can follow a scan as it progresses.
+type RepoEvent struct {
+	Repo   string       `json:"repo"`
+	Pulses []PulseEvent `json:"pulses"`
+}
+
+// PulseEvent holds the built-in metrics of a pulse by name.
+type PulseEvent struct {
+	Start   string             `json:"start"`
+	Metrics map[string]float64 `json:"metrics"`
+}
+
+// EventWriter writes repo events in the JSON Lines format, and
+// publishes them to the stream of the serve mode.
+type EventWriter struct {
+	f   *os.File
+	enc *json.Encoder
+	hub *EventHub
+}
+
+// eventsFilePath returns the path of the events file, which is empty if
+// no events file is written. With no files, the events are only
+// published in serve mode.
+func eventsFilePath() string {
+	if *noFiles && *eventsFile != "" {
+		fmt.Printf("note: no files are written, so the events file %s is not written\n", *eventsFile)
+		return ""
+	}
+	return *eventsFile
+}
+
+// newEventWriter creates the events file, if path is not empty, and
+// publishes the events to hub, if not nil. If neither is set, no events
+// are written, and a nil writer is returned which ignores all events.
+func newEventWriter(path string, hub *EventHub) (*EventWriter, error) {
+	if path == "" && hub == nil {
+		return nil, nil
+	}
+	w := &EventWriter{hub: hub}
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		w.f = f
+		w.enc = json.NewEncoder(f)
+	}
+	return w, nil
+}
+
+// Write writes the event of a repo. The file is not buffered, so the
+// event is visible to readers straight away.
+func (w *EventWriter) Write(config Config, repo string, pulses []Pulse) error {
+	if w == nil {
+		return nil
+	}
+	e := RepoEvent{
+		Repo:   repo,
+		Pulses: make([]PulseEvent, 0, len(pulses)),
+	}
+	for _, p := range pulses {
+		e.Pulses = append(e.Pulses, PulseEvent{
+			Start:   pulseDate(config, p.Start),
+			Metrics: omitMetrics(config, pulseMetricValues(p)),
+		})
+	}
+	if w.hub != nil {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		w.hub.Publish("repo", data)
+	}
+	if w.enc == nil {
+		return nil
+	}
+	return w.enc.Encode(e)
+}
+
+// Close closes the events file. It may be called more than once.
+func (w *EventWriter) Close() error {
+	if w == nil || w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	w.enc = nil
+	return err
+}
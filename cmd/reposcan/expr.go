@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr is a parsed arithmetic expression over named variables. The
+// supported syntax is numbers, variable names, parentheses, unary minus
+// and the binary operators + - * /.
+type Expr interface {
+	Eval(vars map[string]float64) float64
+	// Vars appends the variable names referenced by the expression.
+	Vars(names []string) []string
+}
+
+type exprNumber float64
+
+func (e exprNumber) Eval(vars map[string]float64) float64 { return float64(e) }
+func (e exprNumber) Vars(names []string) []string         { return names }
+
+type exprVar string
+
+func (e exprVar) Eval(vars map[string]float64) float64 { return vars[string(e)] }
+func (e exprVar) Vars(names []string) []string         { return append(names, string(e)) }
+
+type exprNeg struct {
+	x Expr
+}
+
+func (e exprNeg) Eval(vars map[string]float64) float64 { return -e.x.Eval(vars) }
+func (e exprNeg) Vars(names []string) []string         { return e.x.Vars(names) }
+
+type exprBinary struct {
+	op   byte
+	l, r Expr
+}
+
+func (e exprBinary) Eval(vars map[string]float64) float64 {
+	l := e.l.Eval(vars)
+	r := e.r.Eval(vars)
+	switch e.op {
+	case '+':
+		return l + r
+	case '-':
+		return l - r
+	case '*':
+		return l * r
+	case '/':
+		// Consistent with the built-in metrics, an empty
+		// denominator yields zero.
+		if r == 0 {
+			return 0
+		}
+		return l / r
+	default:
+		panic("not a valid operator")
+	}
+}
+
+func (e exprBinary) Vars(names []string) []string {
+	return e.r.Vars(e.l.Vars(names))
+}
+
+// parseExpr parses an arithmetic expression.
+func parseExpr(src string) (Expr, error) {
+	p := &exprParser{src: src}
+	p.next()
+	e, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok != "" {
+		return nil, fmt.Errorf("unexpected %q in expression %q", p.tok, src)
+	}
+	return e, nil
+}
+
+type exprParser struct {
+	src string
+	pos int
+	tok string
+}
+
+// next advances to the next token. The empty token marks the end.
+func (p *exprParser) next() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+	if p.pos >= len(p.src) {
+		p.tok = ""
+		return
+	}
+
+	start := p.pos
+	c := rune(p.src[p.pos])
+	switch {
+	case unicode.IsDigit(c) || c == '.':
+		for p.pos < len(p.src) && (unicode.IsDigit(rune(p.src[p.pos])) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+	case unicode.IsLetter(c) || c == '_':
+		for p.pos < len(p.src) && (unicode.IsLetter(rune(p.src[p.pos])) || unicode.IsDigit(rune(p.src[p.pos])) || p.src[p.pos] == '_') {
+			p.pos++
+		}
+	default:
+		p.pos++
+	}
+	p.tok = p.src[start:p.pos]
+}
+
+func (p *exprParser) parseSum() (Expr, error) {
+	l, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "+" || p.tok == "-" {
+		op := p.tok[0]
+		p.next()
+		r, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		l = exprBinary{op: op, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseProduct() (Expr, error) {
+	l, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "*" || p.tok == "/" {
+		op := p.tok[0]
+		p.next()
+		r, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		l = exprBinary{op: op, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseFactor() (Expr, error) {
+	tok := p.tok
+	switch {
+	case tok == "":
+		return nil, fmt.Errorf("unexpected end of expression %q", p.src)
+	case tok == "-":
+		p.next()
+		x, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return exprNeg{x: x}, nil
+	case tok == "(":
+		p.next()
+		x, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, fmt.Errorf("missing ) in expression %q", p.src)
+		}
+		p.next()
+		return x, nil
+	case unicode.IsDigit(rune(tok[0])) || tok[0] == '.':
+		v, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q in expression %q", tok, p.src)
+		}
+		p.next()
+		return exprNumber(v), nil
+	case unicode.IsLetter(rune(tok[0])) || tok[0] == '_':
+		p.next()
+		return exprVar(strings.ToLower(tok)), nil
+	default:
+		return nil, fmt.Errorf("unexpected %q in expression %q", tok, p.src)
+	}
+}
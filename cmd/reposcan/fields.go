@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shurcooL/githubv4"
+)
+
+// optionalFields are the nested PR fields that Fetch.OmitFields may leave
+// out of the queries, for tokens not permitted to read them, with the
+// pulse metrics that depend on each.
+var optionalFields = map[string][]string{
+	"reviews":   {"merged_unapproved", "approval_hours"},
+	"labels":    {},
+	"comments":  {"review_comments_avg"},
+	"merged_by": {"merged_self", "merged_other", "self_merge_rate"},
+}
+
+// validateOmitFields checks that only optional fields are omitted, and
+// that no setting or custom metric requires an omitted field. A note lists the metrics
+// omitted with each field.
+func validateOmitFields(config Config) error {
+	// The custom metric referencing each metric
+	referenced := make(map[string]string)
+	for custom, src := range config.Settings.CustomMetrics {
+		// Syntax errors are reported with the custom metrics
+		if e, err := parseExpr(src); err == nil {
+			for _, name := range e.Vars(nil) {
+				referenced[name] = custom
+			}
+		}
+	}
+
+	for _, field := range config.Settings.Fetch.OmitFields {
+		metrics, ok := optionalFields[field]
+		if !ok {
+			return fmt.Errorf("unknown omitted field %q", field)
+		}
+		if len(metrics) > 0 {
+			fmt.Printf("note: the %s field is omitted, so the %s metrics are omitted\n", field, strings.Join(metrics, ", "))
+		}
+		for _, name := range metrics {
+			if _, ok := config.Thresholds[name]; ok {
+				return fmt.Errorf("threshold on %q requires the omitted %s field", name, field)
+			}
+			if _, ok := config.Baseline[name]; ok {
+				return fmt.Errorf("baseline tolerance on %q requires the omitted %s field", name, field)
+			}
+			if custom, ok := referenced[name]; ok {
+				return fmt.Errorf("custom metric %q on %q requires the omitted %s field", custom, name, field)
+			}
+		}
+	}
+
+	if omittedField(config, "reviews") && config.Settings.PR.ReviewTurnaround {
+		return fmt.Errorf("reviewer turnaround requires the omitted reviews field")
+	}
+	if omittedField(config, "merged_by") && config.Settings.Contributors.Field == "merged_by" {
+		return fmt.Errorf("the merged_by contributor field requires the omitted merged_by field")
+	}
+	if omittedField(config, "labels") && len(config.Settings.PR.LabelWeights) > 0 {
+		fmt.Printf("note: the labels field is omitted, so the label weights are ignored\n")
+	}
+	return nil
+}
+
+// omittedField returns true if the nested PR field is omitted from the
+// queries with Fetch.OmitFields.
+func omittedField(config Config, field string) bool {
+	for _, f := range config.Settings.Fetch.OmitFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// includeField returns the query variable including the nested PR field,
+// unless it is omitted.
+func includeField(config Config, field string) githubv4.Boolean {
+	return githubv4.Boolean(!omittedField(config, field))
+}
+
+// omittedMetric returns true if the pulse metric depends on an omitted
+// field, so it has no value.
+func omittedMetric(config Config, name string) bool {
+	for _, field := range config.Settings.Fetch.OmitFields {
+		for _, m := range optionalFields[field] {
+			if m == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// omitMetrics removes the metrics depending on omitted fields from the
+// values.
+func omitMetrics(config Config, values map[string]float64) map[string]float64 {
+	for name := range values {
+		if omittedMetric(config, name) {
+			delete(values, name)
+		}
+	}
+	return values
+}
+
+// metricValue returns the formatted value of a pulse metric, or no value
+// if it depends on an omitted field.
+func metricValue(config Config, name string, value string) string {
+	if omittedMetric(config, name) {
+		return ""
+	}
+	return value
+}
@@ -0,0 +1,25 @@
+package main
+
+// PulseHook post-processes the pulses of a repo before they are written,
+// for example to redact or derive values. It returns the pulses to use,
+// which may be the given slice modified in place.
+type PulseHook func(org, repo string, pulses []Pulse) []Pulse
+
+// pulseHooks are run in registration order. The CLI registers none, so
+// by default the pulses are written unchanged.
+var pulseHooks []PulseHook
+
+// RegisterPulseHook adds a hook to run on the pulses of every repo. It
+// must be called before the scan starts, typically from the init
+// function of a file added to the build.
+func RegisterPulseHook(h PulseHook) {
+	pulseHooks = append(pulseHooks, h)
+}
+
+// runPulseHooks runs the registered hooks on the pulses of a repo.
+func runPulseHooks(org, repo string, pulses []Pulse) []Pulse {
+	for _, h := range pulseHooks {
+		pulses = h(org, repo, pulses)
+	}
+	return pulses
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// influxTag escapes s for use as a tag value in the line protocol.
+func influxTag(s string) string {
+	return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `).Replace(s)
+}
+
+func genInflux(config Config, repos map[string]*Repo) error {
+	return writeFile(config.Settings.Influx, "influx", func(out io.Writer) error {
+		return writeInflux(out, config, repos)
+	})
+}
+
+// writeInflux writes a line per pulse of each repo to out in the Influx
+// line protocol, with the org and repo as tags, the built-in pulse
+// metrics as fields, and the pulse start as the timestamp in
+// nanoseconds.
+func writeInflux(out io.Writer, config Config, repos map[string]*Repo) error {
+	b := bufio.NewWriter(out)
+	for _, k := range config.Repos {
+		// Searches are keyed search/<name>, which splits the same way
+		org, repo, err := orgRepoSplit(k)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range repos[k].pulses {
+			metrics := omitMetrics(config, pulseMetricValues(p))
+			fields := make([]string, 0, len(pulseMetrics))
+			for _, m := range pulseMetrics {
+				// Fields without a value are left out of the line
+				if _, ok := metrics[m.Name]; !ok {
+					continue
+				}
+				fields = append(fields, m.Name+"="+strconv.FormatFloat(metrics[m.Name], 'g', -1, 64))
+			}
+			fmt.Fprintf(b, "reposcan,org=%s,repo=%s %s %d\n", influxTag(org), influxTag(repo), strings.Join(fields, ","), p.Start.UnixNano())
+		}
+	}
+	return b.Flush()
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	deviceCodeURL  = "https://github.com/login/device/code"
+	accessTokenURL = "https://github.com/login/oauth/access_token"
+
+	// The scopes of a classic token suitable for a scan
+	loginScopes = "repo read:user"
+)
+
+// deviceCode is the response of Github to a device authorization
+// request.
+type deviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+}
+
+// deviceToken is the response of Github to a token poll. Until the user
+// completes the authorization, Error is set instead of AccessToken.
+type deviceToken struct {
+	AccessToken string `json:"access_token"`
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+	Interval    int    `json:"interval"`
+}
+
+// runLogin obtains a token with the OAuth device flow of Github, and
+// writes it to .token. The user enters the printed code in a browser,
+// while the token is polled for. The args are the flags following the
+// login command.
+func runLogin(args []string) error {
+	flags := flag.NewFlagSet("login", flag.ExitOnError)
+	clientID := flags.String("client-id", "", "the client `id` of the OAuth app, with the device flow enabled")
+	flags.Parse(args)
+
+	fmt.Printf("reposcan v%s login\n", version)
+
+	if *clientID == "" {
+		err := errors.New("no client id")
+		fmt.Println("Error starting login: the --client-id of an OAuth app with device flow enabled is required")
+		return err
+	}
+
+	ctx := context.Background()
+	var code deviceCode
+	err := devicePost(ctx, deviceCodeURL, url.Values{
+		"client_id": {*clientID},
+		"scope":     {loginScopes},
+	}, &code)
+	if err != nil {
+		fmt.Println("Error requesting device code:", err)
+		return err
+	}
+
+	fmt.Printf("open %s and enter the code: %s\n", code.VerificationURI, code.UserCode)
+	fmt.Printf("waiting for authorization...\n")
+
+	token, err := pollToken(ctx, *clientID, code)
+	if err != nil {
+		fmt.Println("Error obtaining token:", err)
+		return err
+	}
+
+	err = os.WriteFile(".token", []byte(token+"\n"), 0600)
+	if err != nil {
+		fmt.Println("Error writing token file:", err)
+		return err
+	}
+	fmt.Println("token written to .token")
+	return nil
+}
+
+// pollToken polls for the token at the interval Github asks for, until
+// the user authorizes or denies the request, or the code expires.
+func pollToken(ctx context.Context, clientID string, code deviceCode) (string, error) {
+	interval := time.Duration(code.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+
+		var t deviceToken
+		err := devicePost(ctx, accessTokenURL, url.Values{
+			"client_id":   {clientID},
+			"device_code": {code.DeviceCode},
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		}, &t)
+		if err != nil {
+			return "", err
+		}
+
+		switch t.Error {
+		case "":
+			if t.AccessToken == "" {
+				return "", errors.New("no token in response")
+			}
+			return t.AccessToken, nil
+		case "authorization_pending":
+		case "slow_down":
+			// Github asks for a longer interval from now on
+			if t.Interval > 0 {
+				interval = time.Duration(t.Interval) * time.Second
+			} else {
+				interval += 5 * time.Second
+			}
+		default:
+			if t.Description != "" {
+				return "", fmt.Errorf("%s: %s", t.Error, t.Description)
+			}
+			return "", errors.New(t.Error)
+		}
+	}
+	return "", errors.New("the code expired before authorization")
+}
+
+// devicePost posts a form to an OAuth endpoint of Github, and decodes
+// the JSON response into v.
+func devicePost(ctx context.Context, endpoint string, form url.Values, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", endpoint, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+)
+
+const manifestFile = "manifest.json"
+
+// ManifestEntry describes a file written by a run.
+type ManifestEntry struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	Type string `json:"type"`
+}
+
+// manifest lists the files written so far in this run, in the order
+// they were written.
+var manifest []ManifestEntry
+
+// addManifest records a written file of the given kind in the manifest.
+// A file written more than once is only listed once.
+func addManifest(name string, kind string) error {
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	for i := range manifest {
+		if manifest[i].Path == name {
+			manifest[i].Size = info.Size()
+			return nil
+		}
+	}
+	manifest = append(manifest, ManifestEntry{Path: name, Size: info.Size(), Type: kind})
+	return nil
+}
+
+// saveManifest writes the manifest of the files written by the run, so
+// later pipeline steps can find the outputs without globbing. The
+// manifest does not list itself.
+func saveManifest(name string) error {
+	entries := manifest
+	if entries == nil {
+		entries = []ManifestEntry{}
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(name, "manifest", func(out io.Writer) error {
+		_, err := out.Write(data)
+		return err
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+)
+
+// megaPR returns true if a PR has more lines than PR.MegaLines. Mega PRs
+// are flagged regardless of the size weight thresholds, by their lines
+// changed as is, without the additions and deletions weights.
+func megaPR(config Config, p Pull) bool {
+	return config.Settings.PR.MegaLines > 0 && pullLines(p) > config.Settings.PR.MegaLines
+}
+
+// pullLines returns the lines changed by a PR, which is the sum of its
+// additions and deletions.
+func pullLines(p Pull) int {
+	return p.Additions + p.Deletions
+}
+
+func genMegaPRs(config Config, repos map[string]*Repo) error {
+	return writeFile("mega-prs.csv", "mega prs", func(out io.Writer) error {
+		return writeMegaPRs(out, config, repos)
+	})
+}
+
+// writeMegaPRs writes a row per merged mega PR of each pulse of each repo
+// to out, with its size in lines and files.
+func writeMegaPRs(out io.Writer, config Config, repos map[string]*Repo) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"Repo", "Pulse", "PR", "Lines", "Files"})
+	for _, k := range config.Repos {
+		if repos[k] == nil {
+			continue
+		}
+		for _, p := range repos[k].pulses {
+			for _, pull := range p.Pulls {
+				if !pull.Merged || !megaPR(config, pull) {
+					continue
+				}
+				w.Write([]string{
+					k,
+					pulseDate(config, p.Start),
+					fmt.Sprintf("%d", pull.Number),
+					fmt.Sprintf("%d", pullLines(pull)),
+					fmt.Sprintf("%d", pull.Files),
+				})
+			}
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
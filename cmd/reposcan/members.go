@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/shurcooL/githubv4"
+)
+
+type OrgMembersEntry struct {
+	Organization struct {
+		MembersWithRole struct {
+			Nodes []struct {
+				Login string
+			}
+			PageInfo struct {
+				EndCursor   githubv4.String
+				HasNextPage bool
+			}
+		} `graphql:"membersWithRole(first: 100, after: $cursor)"`
+	} `graphql:"organization(login: $login)"`
+}
+
+// MemberCache holds the members of each repo owner, so the members of
+// an org are only read once per run however many of its repos are
+// scanned.
+type MemberCache struct {
+	client  *githubv4.Client
+	members map[string]map[string]bool
+}
+
+func newMemberCache(client *githubv4.Client) *MemberCache {
+	return &MemberCache{
+		client:  client,
+		members: make(map[string]map[string]bool),
+	}
+}
+
+// Members returns the logins of the members of the owner of a repo. The
+// only member of a user account is the user. Only the members visible to
+// the token are returned, which are the public members unless the token
+// has access to the org membership.
+func (c *MemberCache) Members(ctx context.Context, org string, repo string) (map[string]bool, error) {
+	if members, ok := c.members[org]; ok {
+		return members, nil
+	}
+
+	info, err := repoInfo(ctx, c.client, org, repo)
+	if err != nil {
+		return nil, err
+	}
+	members := map[string]bool{org: true}
+	if info.Repository.Owner.Typename == "Organization" {
+		members, err = orgMembers(ctx, c.client, org)
+		if err != nil {
+			return nil, err
+		}
+	}
+	c.members[org] = members
+	return members, nil
+}
+
+// orgMembers reads all the members of an org.
+func orgMembers(ctx context.Context, client *githubv4.Client, org string) (map[string]bool, error) {
+	var q OrgMembersEntry
+	variables := map[string]interface{}{
+		"login":  githubv4.String(org),
+		"cursor": (*githubv4.String)(nil),
+	}
+	members := make(map[string]bool)
+	for {
+		err := client.Query(ctx, &q, variables)
+		if err != nil {
+			return nil, fmt.Errorf("org members request failed: %w", err)
+		}
+		for _, n := range q.Organization.MembersWithRole.Nodes {
+			members[n.Login] = true
+		}
+		if !q.Organization.MembersWithRole.PageInfo.HasNextPage {
+			break
+		}
+		variables["cursor"] = githubv4.NewString(q.Organization.MembersWithRole.PageInfo.EndCursor)
+	}
+	return members, nil
+}
+
+// excludedMember returns true if the login is a member of the repo
+// owner, excluded with Contributors.ExcludeMembers.
+func excludedMember(config Config, login string) bool {
+	return config.members[login]
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// graphqlErrors returns the messages of the errors in a GraphQL
+// response, or nil if err is not such an error (such as a transport
+// failure). Github returns errors alongside partial data, for example
+// when a single aliased repo of a batch times out. The GraphQL client
+// decodes the data before reporting the errors, so the data is usable
+// where present.
+func graphqlErrors(err error) []string {
+	if err == nil {
+		return nil
+	}
+	// The client does not export its error type, which is a slice of
+	// errors with a message each.
+	v := reflect.ValueOf(err)
+	if v.Kind() != reflect.Slice || v.Type().Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	msgs := make([]string, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		m := v.Index(i).FieldByName("Message")
+		if !m.IsValid() || m.Kind() != reflect.String {
+			return nil
+		}
+		msgs = append(msgs, m.String())
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return msgs
+}
+
+// logPartial prints the errors of a partial GraphQL response.
+func logPartial(key string, msgs []string) {
+	for _, m := range msgs {
+		fmt.Printf("\n%s: warning: partial response: %s\n", key, m)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shurcooL/githubv4"
+)
+
+// PRRef identifies a single PR of a repo.
+type PRRef struct {
+	Org    string
+	Repo   string
+	Number int
+}
+
+func (r PRRef) String() string {
+	return fmt.Sprintf("%s/%s#%d", r.Org, r.Repo, r.Number)
+}
+
+// prRefs is a flag value collecting the PRs given as org/repo#number,
+// which may be repeated.
+type prRefs []PRRef
+
+func (l *prRefs) String() string {
+	refs := make([]string, 0, len(*l))
+	for _, r := range *l {
+		refs = append(refs, r.String())
+	}
+	return strings.Join(refs, " ")
+}
+
+func (l *prRefs) Set(value string) error {
+	key, number, ok := strings.Cut(value, "#")
+	if !ok {
+		return fmt.Errorf("pr %q is not of the form org/repo#number", value)
+	}
+	org, repo, err := orgRepoSplit(key)
+	if err != nil {
+		return err
+	}
+	n, err := strconv.Atoi(number)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("invalid pr number %q", number)
+	}
+	*l = append(*l, PRRef{Org: org, Repo: repo, Number: n})
+	return nil
+}
+
+var onlyPRs prRefs
+
+func init() {
+	flag.Var(&onlyPRs, "pr", "only classify the PR `org/repo#number` in the pulses, may be repeated")
+}
+
+type PullEntry struct {
+	Repository struct {
+		DefaultBranchRef struct {
+			Name string
+		}
+		CreatedAt   time.Time
+		PullRequest PrEntry `graphql:"pullRequest(number: $number)"`
+	} `graphql:"repository(owner: $owner, name: $name)"`
+}
+
+// pullEntry reads a single PR, with the default branch and creation time
+// of its repo.
+func pullEntry(ctx context.Context, client *githubv4.Client, config Config, r PRRef) (q PullEntry, err error) {
+	variables := prEntryVariables(config)
+	variables["owner"] = githubv4.String(r.Org)
+	variables["name"] = githubv4.String(r.Repo)
+	variables["number"] = githubv4.Int(r.Number)
+	err = client.Query(ctx, &q, variables)
+	if err != nil {
+		return q, fmt.Errorf("pr request failed: %w", err)
+	}
+	return q, nil
+}
+
+// pullClass returns how a PR counted in a pulse is classified.
+func pullClass(pull Pull) string {
+	switch {
+	case pull.Open:
+		return "open"
+	case pull.Abandoned:
+		return "abandoned"
+	case pull.Merged:
+		return "merged"
+	}
+	return "closed"
+}
+
+// runPRReport reads only the given PRs, and prints the pulses each PR
+// is counted in and how, or why it is not counted at all. The pulses
+// start at the configured start, or else at the creation of the oldest
+// repo of the PRs.
+func runPRReport(ctx context.Context, client *githubv4.Client, config Config, refs []PRRef) error {
+	entries := make([]PullEntry, 0, len(refs))
+	start := now
+	for _, r := range refs {
+		fmt.Printf("%s: reading pr...\n", r)
+		q, err := pullEntry(ctx, client, config, r)
+		if err != nil {
+			fmt.Println("Error reading PR:", err)
+			return err
+		}
+		entries = append(entries, q)
+		if start.After(q.Repository.CreatedAt) {
+			start = q.Repository.CreatedAt
+		}
+	}
+
+	if config.Settings.Graphs.Start != nil {
+		var err error
+		start, err = parseStart(*config.Settings.Graphs.Start, now)
+		if err != nil {
+			fmt.Println("Error parsing starting time:", err)
+			return err
+		}
+	}
+	windows := pulseWindows(config, start, graphsEnd(config))
+	members := newMemberCache(client)
+
+	for i, q := range entries {
+		r := refs[i]
+		p := q.Repository.PullRequest
+
+		// Classified with the settings of the repo, as in a scan
+		rc := repoConfig(config, r.Org+"/"+r.Repo)
+		if config.Settings.Contributors.ExcludeMembers {
+			var err error
+			rc.members, err = members.Members(ctx, r.Org, r.Repo)
+			if err != nil {
+				fmt.Println("Error reading members:", err)
+				return err
+			}
+		}
+
+		login := contributorLogin(rc, p)
+		fmt.Printf("%s: %s by %s, created %s\n", r, strings.ToLower(p.State), login, p.CreatedAt.Format("2006-01-02"))
+
+		switch {
+		case !defaultBranchPR(rc, p.BaseRefName, q.Repository.DefaultBranchRef.Name):
+			fmt.Printf("  not counted: against branch %s instead of %s\n", p.BaseRefName, q.Repository.DefaultBranchRef.Name)
+			continue
+		case !includedState(rc, p.State):
+			fmt.Printf("  not counted: state %s is not included\n", p.State)
+			continue
+		case !allowlistedUser(rc, login):
+			fmt.Printf("  not counted: %s is not allowlisted\n", login)
+			continue
+		}
+
+		// Without the allowlist periods, to tell the pulses left out
+		// by a period
+		unlimited := rc
+		unlimited.Settings.Contributors.Periods = nil
+
+		counted := 0
+		outside := 0
+		lo, hi := candidateWindows(rc, p, windows)
+		for j := lo; j < hi; j++ {
+			w := windows[j]
+			if _, ok := pulsePull(unlimited, p, w.Start, w.End); !ok {
+				continue
+			}
+			pull, ok := pulsePull(rc, p, w.Start, w.End)
+			if !ok {
+				fmt.Printf("  pulse %s: not counted, outside the allowlist period of %s\n", pulseDate(rc, w.Start), login)
+				outside += 1
+				continue
+			}
+			fmt.Printf("  pulse %s: %s\n", pulseDate(rc, w.Start), pullClass(pull))
+			counted += 1
+		}
+		if counted == 0 && outside == 0 {
+			fmt.Printf("  not counted: outside the pulses\n")
+		}
+
+		// Counted PRs may still be excluded from the contributors or
+		// the normalised values
+		switch {
+		case login == "":
+			fmt.Printf("  no contributor, so not counted in the contributors\n")
+		case strings.HasPrefix(login, "renovate"):
+			fmt.Printf("  %s is a bot, so not counted in the contributors\n", login)
+		case excludedMember(rc, login):
+			fmt.Printf("  %s is a member of %s, so excluded from the contributors and normalised values\n", login, r.Org)
+		case normExcluded(rc, login):
+			fmt.Printf("  %s is excluded from the normalised values by norm_exclude\n", login)
+		}
+	}
+
+	fmt.Println("done.")
+	return nil
+}
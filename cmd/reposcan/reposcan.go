@@ -4,10 +4,20 @@ import (
 	"context"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"math"
+	"net/url"
 	"os"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/shurcooL/githubv4"
 	"github.com/snabb/isoweek"
@@ -16,27 +26,217 @@ import (
 
 const version = "1.0"
 
+// now is the current time used for all the metrics of a run, so that
+// every part of a run agrees on the current time. It can be replaced
+// for reproducible output.
+var now = time.Now().UTC()
+
+var (
+	cpuProfile = flag.String("cpuprofile", "", "write a CPU profile to `file`")
+	memProfile = flag.String("memprofile", "", "write a memory profile to `file`")
+	quiet      = flag.Bool("quiet", false, "suppress progress output")
+	anonymise  = flag.Bool("anonymise", false, "replace contributor logins with pseudonyms in all outputs")
+	anonMap    = flag.String("anonymise-map", "", "persist the pseudonym mapping in `file` so it is stable between runs")
+	author     = flag.String("author", "", "only report the PRs of `login` across all repos")
+	verbose    = flag.Bool("verbose", false, "print additional details such as fetch statistics")
+	eventsFile = flag.String("events", "", "write a JSON line with the pulse metrics of each repo to `file` as it completes")
+	serve      = flag.String("serve", "", "run the scan in the background, streaming the events of each repo as server-sent events on /stream at `addr`")
+	doctor     = flag.Bool("doctor", false, "check the token, config, Github access and output directory, then exit")
+	metrics    = flag.Bool("list-metrics", false, "print the metrics usable in custom metrics and thresholds, then exit")
+	appendMode = flag.Bool("append", false, "append the new pulses to existing PR graph files instead of rewriting them")
+	noFiles    = flag.Bool("no-files", false, "write no output files, and print a summary of the latest pulse of each repo instead")
+	audit      = flag.Bool("audit", false, "write the PRs counted in each pulse of each repo to <org>-<repo>-audit.csv")
+	baseline   = flag.String("baseline", "", "compare the latest pulses against the summary in `file`, failing on regressions")
+
+	// Settings overrides, taking precedence over the config file.
+	cooldown    = flag.Int("cooldown", 0, "override the contributor cooldown period in `months`")
+	prHigh      = flag.Int("pr-high", 0, "override the PR high `lines` threshold")
+	prLow       = flag.Int("pr-low", 0, "override the PR low `lines` threshold")
+	windowWeeks = flag.Int("window-weeks", 0, "override the graph window with a number of `weeks`")
+)
+
 type Settings struct {
 	Contributors struct {
-		Cooldown  int      `json:"cooldown"`
-		Allowlist []string `json:"allowlist"`
+		Cooldown        int                 `json:"cooldown"`
+		Allowlist       []string            `json:"allowlist"`
+		RepoAllowlists  map[string][]string `json:"repo_allowlists"`
+		Periods         map[string]Period   `json:"allowlist_periods"`
+		TopContributors int                 `json:"top_contributors"`
+		EmitUsers       *bool               `json:"emit_users"`
+		EmitDetailed    bool                `json:"emit_users_detailed"`
+		EmitMatrix      bool                `json:"emit_users_matrix"`
+		EmitNew         bool                `json:"emit_new_contributors"`
+		Basis           string              `json:"basis"`
+		Field           string              `json:"contributor_field"`
+		NormMode        string              `json:"norm_mode"`
+		HalfLife        int                 `json:"half_life_days"`
+		MinPRs          int                 `json:"min_prs_for_contributor"`
+		NormExclude     []string            `json:"norm_exclude"`
+		ExcludeMembers  bool                `json:"exclude_org_members"`
+		EmailDomains    bool                `json:"email_domains"`
 	} `json:"contributors"`
 	PR struct {
-		High int `json:"high"`
-		Low  int `json:"low"`
+		High             int                `json:"high"`
+		Low              int                `json:"low"`
+		States           []string           `json:"states"`
+		Approvals        int                `json:"approvals"`
+		StaleDays        int                `json:"stale_days"`
+		MaxOpenAge       int                `json:"max_open_age_days"`
+		MegaLines        int                `json:"mega_lines"`
+		EmitMegaPRs      bool               `json:"emit_mega_prs"`
+		LineSource       string             `json:"line_source"`
+		AdditionsWeight  *float64           `json:"additions_weight"`
+		DeletionsWeight  *float64           `json:"deletions_weight"`
+		LabelWeights     map[string]float64 `json:"label_weights"`
+		MinBodyLength    int                `json:"min_body_length"`
+		BusinessHours    bool               `json:"business_hours"`
+		Holidays         []string           `json:"holidays"`
+		ReviewTurnaround bool               `json:"reviewer_turnaround"`
+		BranchAliases    map[string]string  `json:"branch_aliases"`
 	} `json:"pr"`
 	Graphs struct {
-		Start *string `json:"start"`
-		Window int `json:"window"`
+		Start          *string `json:"start"`
+		Window         int     `json:"window"`
+		CompareSort    string  `json:"compare_sort"`
+		WindowBasis    string  `json:"window_basis"`
+		MergeOnce      bool    `json:"merge_once"`
+		PulseIndex     bool    `json:"pulse_index"`
+		Precision      *int    `json:"precision"`
+		ReportTZ       string  `json:"report_tz"`
+		EndPadding     *int    `json:"end_padding_days"`
+		ProrateCurrent bool    `json:"prorate_current"`
+		Throughput     bool    `json:"contributor_throughput"`
+		Cadence        string  `json:"cadence"`
 	} `json:"graphs"`
+	Fetch struct {
+		Rescan       int      `json:"rescan"`
+		Streaming    bool     `json:"streaming"`
+		APIMode      string   `json:"api_mode"`
+		APIURL       string   `json:"api_url"`
+		BatchSize    int      `json:"batch_size"`
+		UpdatedOnly  bool     `json:"updated_only"`
+		LookbackDays int      `json:"lookback_days"`
+		SeedDays     int      `json:"contributor_lookback_days"`
+		OmitFields   []string `json:"omit_fields"`
+	} `json:"fetch"`
+	CustomMetrics map[string]string `json:"custom_metrics"`
+	SQLite        string            `json:"sqlite"`
+	Influx        string            `json:"influx"`
+}
+
+// Search is a named GitHub search query whose matching PRs are treated
+// as if they belong to a single repo.
+type Search struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+// Period is the time an allowlisted contributor was part of the team, as
+// dates (2006-01-02). Either date may be omitted, and the until date is
+// inclusive.
+type Period struct {
+	From  string `json:"from"`
+	Until string `json:"until"`
+}
+
+// Overlaps returns true if the period overlaps the window [start, end).
+// The dates are validated with the config.
+func (p Period) Overlaps(start time.Time, end time.Time) bool {
+	if p.From != "" {
+		from, _ := time.Parse("2006-01-02", p.From)
+		if !from.Before(end) {
+			return false
+		}
+	}
+	if p.Until != "" {
+		until, _ := time.Parse("2006-01-02", p.Until)
+		if !until.AddDate(0, 0, 1).After(start) {
+			return false
+		}
+	}
+	return true
+}
+
+// Release is a release of a repo, used to annotate the graphs.
+type Release struct {
+	Tag  string `json:"tag"`
+	Date string `json:"date"`
 }
 
 type Config struct {
-	Settings Settings `json:"settings"`
-	Repos    []string `json:"repos"`
+	Settings  Settings             `json:"settings"`
+	Repos     []string             `json:"repos"`
+	ReposFile string               `json:"repos_file"`
+	Searches  []Search             `json:"searches"`
+	Releases  map[string][]Release `json:"releases"`
+	// Bounds on metrics of the latest pulse, by metric name
+	Thresholds map[string]Threshold `json:"thresholds"`
+	// Tolerated changes of metrics from the baseline, by metric name
+	Baseline map[string]Tolerance `json:"baseline"`
+	// Members of the owner of the repo being processed, if excluded
+	members map[string]bool
 }
 
 func main() {
+	flag.Parse()
+
+	if *cpuProfile != "" {
+		f, err := os.Create(*cpuProfile)
+		if err != nil {
+			fmt.Println("Error creating CPU profile:", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		err = pprof.StartCPUProfile(f)
+		if err != nil {
+			fmt.Println("Error starting CPU profile:", err)
+			os.Exit(1)
+		}
+	}
+
+	var err error
+	if flag.Arg(0) == "login" {
+		err = runLogin(flag.Args()[1:])
+	} else if flag.Arg(0) == "combine" {
+		err = runCombine(flag.Args()[1:])
+	} else if *metrics {
+		err = listMetrics(os.Stdout)
+	} else if *doctor {
+		err = runDoctor()
+	} else if *serve != "" {
+		err = runServe(*serve)
+	} else {
+		err = run()
+	}
+
+	if *cpuProfile != "" {
+		pprof.StopCPUProfile()
+	}
+
+	if *memProfile != "" {
+		f, err := os.Create(*memProfile)
+		if err != nil {
+			fmt.Println("Error creating memory profile:", err)
+			os.Exit(1)
+		}
+		// Get up-to-date statistics
+		runtime.GC()
+		err = pprof.WriteHeapProfile(f)
+		f.Close()
+		if err != nil {
+			fmt.Println("Error writing memory profile:", err)
+			os.Exit(1)
+		}
+	}
+
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// run performs a complete scan. Errors are reported as they occur, and
+// the returned error only signals failure to the caller.
+func run() error {
 	fmt.Printf("reposcan v%s\n", version)
 
 	fmt.Printf("loading token...\n")
@@ -44,7 +244,7 @@ func main() {
 	data, err := os.ReadFile(".token")
 	if err != nil {
 		fmt.Println("Error opening token file:", err)
-		return
+		return err
 	}
 	token := strings.Trim(string(data), "\n\r")
 
@@ -53,47 +253,105 @@ func main() {
 	jsonData, err := os.ReadFile("config.json")
 	if err != nil {
 		fmt.Println("Error reading file:", err)
-		return
+		return err
 	}
 
 	var config Config
 	err = json.Unmarshal(jsonData, &config)
 	if err != nil {
 		fmt.Println("Error unmarshaling JSON data:", err)
-		return
+		return err
+	}
+
+	overrideSettings(&config)
+
+	err = readReposFile(&config)
+	if err != nil {
+		fmt.Println("Error reading repos file:", err)
+		return err
+	}
+	dedupeRepos(&config)
+
+	err = validateConfig(config)
+	if err != nil {
+		fmt.Println("Invalid config:", err)
+		return err
 	}
+	disableHistoryFeatures(&config)
 
 	fmt.Println("authenticating...")
 
 	ctx := context.Background()
 	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
 	tc := oauth2.NewClient(ctx, ts)
-	client := githubv4.NewClient(tc)
+	tc.Transport = &retryAfterTransport{base: tc.Transport}
+	client := newGraphQLClient(config, tc)
+	source, err := newSource(config, tc)
+	if err != nil {
+		fmt.Println("Invalid config:", err)
+		return err
+	}
+
+	if len(onlyPRs) > 0 {
+		if config.Settings.Fetch.APIMode == "rest" {
+			err = fmt.Errorf("pr mode reads single PRs from the graphql api")
+			fmt.Println("Rest api mode not supported:", err)
+			return err
+		}
+		return runPRReport(ctx, client, config, onlyPRs)
+	}
 
 	repos := make(map[string]*Repo)
 	users := make(map[string]User)
+	details := make(map[string]*UserDetail)
 
-	startGraphs := time.Now().UTC()
+	startGraphs := now
 
 	// Load PRs from repos
 	for _, k := range config.Repos {
 		org, repo, err := orgRepoSplit(k)
 		if err != nil {
 			fmt.Println("Invalid repo:", err)
-			return
+			return err
+		}
+
+		// In streaming mode the PRs are only read once the pulse
+		// windows are known, for which only the creation time is
+		// needed now.
+		if config.Settings.Fetch.Streaming {
+			start, err := repoCreated(ctx, client, org, repo)
+			if err != nil {
+				fmt.Println("Error reading repo:", err)
+				return err
+			}
+			repos[k] = &Repo{
+				created: start,
+				stream:  true,
+			}
+			if startGraphs.After(start) {
+				startGraphs = start
+			}
+			continue
 		}
 
 		// Get all PRs for this repo
-		start, prs, err := repoPulls(ctx, client, org, repo)
+		var stats FetchStats
+		start, prs, seed, err := source.RepoPulls(ctx, config, org, repo, &stats)
 		if err != nil {
 			fmt.Println("Error reading PRs:", err)
-			return
+			return err
+		}
+		if *verbose {
+			fmt.Printf("%s/%s: fetched %s\n", org, repo, stats)
 		}
 
 		// No pulse data yet we first need to figure out the
 		// earliest start date to align all graphs
 		repos[k] = &Repo{
-			prs: prs,
+			created: start,
+			prs:     prs,
+			seed:    seed,
+			fetch:   stats,
 		}
 
 		if startGraphs.After(start) {
@@ -102,422 +360,3142 @@ func main() {
 		}
 	}
 
+	dedupePulls(config, repos)
+
+	// Load PRs from searches. Searches are keyed as search/<name> and
+	// from here on are processed exactly like repos.
+	for _, s := range config.Searches {
+		k := searchKey(s)
+
+		var stats FetchStats
+		start, prs, err := source.SearchPulls(ctx, config, s, &stats)
+		if err != nil {
+			fmt.Println("Error searching PRs:", err)
+			return err
+		}
+		if *verbose {
+			fmt.Printf("%s: fetched %s\n", k, stats)
+		}
+
+		repos[k] = &Repo{
+			created: start,
+			prs:     prs,
+			fetch:   stats,
+		}
+		config.Repos = append(config.Repos, k)
+
+		if startGraphs.After(start) {
+			startGraphs = start
+		}
+	}
+
 	// Override for start
 	if config.Settings.Graphs.Start != nil {
-		startGraphs, err = time.Parse("2006-01-02", *config.Settings.Graphs.Start)
+		startGraphs, err = parseStart(*config.Settings.Graphs.Start, now)
 		if err != nil {
 			fmt.Println("Error parsing starting time:", err)
-			return
+			return err
+		}
+	}
+
+	if *author != "" {
+		if config.Settings.Fetch.Streaming {
+			err = fmt.Errorf("author mode requires the PRs to be retained")
+			fmt.Println("Streaming mode not supported:", err)
+			return err
 		}
+		return runAuthorReport(config, repos, startGraphs, *author)
+	}
+
+	eventsPath := eventsFilePath()
+	events, err := newEventWriter(eventsPath, streamHub)
+	if err != nil {
+		fmt.Println("Error creating events file:", err)
+		return err
 	}
+	defer events.Close()
+
+	searches := make(map[string]bool)
+	for _, s := range config.Searches {
+		searches[searchKey(s)] = true
+	}
+	members := newMemberCache(client)
 
 	// Generate pulse data
 	for _, k := range config.Repos {
 		org, repo, err := orgRepoSplit(k)
 		if err != nil {
 			fmt.Println("Invalid repo:", err)
-			return
+			return err
 		}
 		fmt.Printf("%s/%s: generating pulse metrics...\n", org, repo)
 
-		endTime := time.Now().AddDate(0, 0, 1)
-		repoUsers := getUsers(config, repos[k].prs)
-		pulses := getPulses(config, startGraphs, endTime, repos[k].prs, repoUsers)
+		endTime := graphsEnd(config)
+		rc := repoConfig(config, k)
+		if config.Settings.Contributors.ExcludeMembers {
+			if searches[k] {
+				fmt.Printf("%s: warning: searches have no owner, so no members are excluded\n", k)
+			} else {
+				rc.members, err = members.Members(ctx, org, repo)
+				if err != nil {
+					fmt.Println("Error reading members:", err)
+					return err
+				}
+			}
+		}
+
+		var repoUsers map[string]User
+		var pulses []Pulse
+		if repos[k].stream {
+			acc := newPulseAccumulator(rc, startGraphs, endTime)
+			err = repoPullsStream(ctx, client, rc, org, repo, &repos[k].fetch, acc)
+			if err != nil {
+				fmt.Println("Error reading PRs:", err)
+				return err
+			}
+			if *verbose {
+				fmt.Printf("%s/%s: fetched %s\n", org, repo, repos[k].fetch)
+			}
+			repoUsers = acc.Users()
+			pulses = acc.Pulses()
+			repos[k].summary = acc.Summary()
+			repos[k].rows = acc.rows
+		} else {
+			repoUsers = getUsers(rc, repos[k].prs, repos[k].seed)
+			pulses = getPulses(rc, startGraphs, endTime, repos[k].prs, repoUsers)
+			repos[k].summary = repoSummary(rc, repos[k].prs, repoUsers)
+		}
 
 		// Merge with global user list (we will export this for help building allowlists)
-		for k, v := range repoUsers {
-			users[k] = v
+		for login, v := range repoUsers {
+			users[login] = v
+
+			d, ok := details[login]
+			if !ok {
+				d = &UserDetail{RepoPRs: make(map[string]int)}
+				details[login] = d
+			}
+			d.Repos = append(d.Repos, k)
+			d.PRs += v.PRs
+			d.RepoPRs[k] += v.PRs
 		}
 
+		pulses = runPulseHooks(org, repo, pulses)
+		err = orderPulses(pulses)
+		if err != nil {
+			fmt.Println("Error ordering pulses:", err)
+			return err
+		}
 		repos[k].pulses = pulses
 		repos[k].start = startGraphs
 
+		// Checked before writing any files of the repo, so a CI
+		// gate fails early
+		breaches, err := checkThresholds(config, k, pulses)
+		if err != nil {
+			fmt.Println("Error checking thresholds:", err)
+			return err
+		}
+		if len(breaches) > 0 {
+			for _, b := range breaches {
+				fmt.Println("Threshold breached:", b)
+			}
+			return fmt.Errorf("%d thresholds breached", len(breaches))
+		}
+		if config.Settings.Contributors.EmitNew {
+			repos[k].summary.setNewContributors(rc, repoUsers, pulses)
+		}
+
+		if empty := pulsesBefore(pulses, repos[k].created); empty > 0 {
+			fmt.Printf("%s/%s: %d leading pulses are empty as the graphs start before the repo was created on %s\n", org, repo, empty, repos[k].created.Format("2006-01-02"))
+		}
+
+		err = events.Write(config, k, pulses)
+		if err != nil {
+			fmt.Println("Error writing event:", err)
+			return err
+		}
+
 		fmt.Printf("%s/%s: generating pr graph...\n", org, repo)
 
-		err = genPRGraph(org, repo, repos[k].pulses)
+		err = genPRGraph(config, org, repo, repos[k].pulses)
 		if err != nil {
 			fmt.Println("Error writing PR graph:", err)
-			return
+			return err
 		}
 
 		fmt.Printf("%s/%s: generating normalised graph...\n", org, repo)
 
-		err = genNormGraph(org, repo, repos[k].pulses)
+		err = genNormGraph(config, org, repo, repos[k].pulses)
 		if err != nil {
 			fmt.Println("Error writing normalised graph:", err)
-			return
+			return err
+		}
+
+		if releases, ok := config.Releases[k]; ok {
+			fmt.Printf("%s/%s: generating releases...\n", org, repo)
+
+			err = genReleases(config, org, repo, releases, repos[k].pulses)
+			if err != nil {
+				fmt.Println("Error writing releases:", err)
+				return err
+			}
 		}
 	}
 
 	err = genCompareNormGraphs(config, repos)
 	if err != nil {
 		fmt.Println("Error writing normalised comparison graphs:", err)
-		return
+		return err
 	}
 
-	fmt.Printf("generating user list...\n")
-	err = genUsers(users)
+	err = genCompareAbsGraphs(config, repos)
 	if err != nil {
-		fmt.Println("Error writing users to file:", err)
-		return
+		fmt.Println("Error writing absolute comparison graphs:", err)
+		return err
 	}
 
-	fmt.Println("done.")
-}
+	err = genDORA(config, repos)
+	if err != nil {
+		fmt.Println("Error writing DORA summary:", err)
+		return err
+	}
 
-func genCompareNormGraphs(config Config, repos map[string]*Repo) error {
-	graphs := []struct {
-		name string
-		desc string
-	}{
-		{
-			name: "open",
-			desc: "open (norm)",
-		},
-		{
-			name: "merged",
-			desc: "merged (norm)",
-		},
+	var anon *Anonymiser
+	if *anonymise {
+		anon, err = newAnonymiser(*anonMap)
+		if err != nil {
+			fmt.Println("Error loading anonymise mapping:", err)
+			return err
+		}
+		anon.Assign(users)
 	}
 
-	for _, t := range graphs {
+	// The top contributors are ranked over all repos, so the audits
+	// are written once the pulses of all repos are known.
+	top := topContributors(config, repos)
 
-		fmt.Printf("%s: generating normalised comparison graph...\n", t.desc)
+	if *audit {
+		for _, k := range config.Repos {
+			org, repo, _ := orgRepoSplit(k)
+			fmt.Printf("%s/%s: generating audit...\n", org, repo)
 
-		name := fmt.Sprintf("compare-%s.csv", t.name)
-		f, err := os.Create(name)
+			err = genAudit(config, org, repo, repos[k].pulses, top, anon)
+			if err != nil {
+				fmt.Println("Error writing audit:", err)
+				return err
+			}
+		}
+	}
+
+	fmt.Printf("generating contributor heatmap...\n")
+	err = genContributorHeatmap(config, repos, top, anon)
+	if err != nil {
+		fmt.Println("Error writing contributor heatmap:", err)
+		return err
+	}
+
+	if config.Settings.Graphs.Throughput {
+		fmt.Printf("generating contributor throughput...\n")
+		err = genPerContributorThroughput(config, repos, top, anon)
 		if err != nil {
-			return fmt.Errorf("cannot create graph file: %w", err)
+			fmt.Println("Error writing contributor throughput:", err)
+			return err
 		}
+	}
 
-		w := csv.NewWriter(f)
-		w.Write([]string{fmt.Sprintf("Compare: %s", t.desc)})
+	if config.Settings.Contributors.EmailDomains {
+		fmt.Printf("generating domain summary...\n")
+		err = genDomainSummary(config, repos)
+		if err != nil {
+			fmt.Println("Error writing domain summary:", err)
+			return err
+		}
+	}
 
-		for i, k := range config.Repos {
-			if i == 0 {
-				// The first iteration needs to plot the dates
-				line := make([]string, 0)
-				line = append(line, "Pulse")
-				for _, v := range repos[k].pulses {
-					line = append(line, v.Start.Format("2006-01-02"))
-				}
-				w.Write(line)
-			}
+	if config.Settings.PR.EmitMegaPRs {
+		fmt.Printf("generating mega prs...\n")
+		err = genMegaPRs(config, repos)
+		if err != nil {
+			fmt.Println("Error writing mega prs:", err)
+			return err
+		}
+	}
 
-			line := make([]string, 0)
-			line = append(line, k)
-			for _, v := range repos[k].pulses {
-				line = append(line, func(t string, p Pulse) string {
-					switch t {
-					case "open":
-						return fmt.Sprintf("%0.2f", p.PrOpenNorm)
-					case "merged":
-						return fmt.Sprintf("%0.2f", p.PrMergedNorm)
-					default:
-						panic("not a valid metric type")
-					}
-				}(t.name, v))
-			}
-			w.Write(line)
+	if config.Settings.PR.ReviewTurnaround {
+		fmt.Printf("generating reviewer turnaround...\n")
+		err = genReviewerTurnaround(config, repos, startGraphs, top, anon)
+		if err != nil {
+			fmt.Println("Error writing reviewer turnaround:", err)
+			return err
 		}
+	}
 
-		w.Flush()
-		f.Sync()
-		f.Close()
+	if config.Settings.Contributors.EmitUsers == nil || *config.Settings.Contributors.EmitUsers {
+		fmt.Printf("generating user list...\n")
+		err = genUsers(users, top, anon)
+		if err != nil {
+			fmt.Println("Error writing users to file:", err)
+			return err
+		}
 	}
-	return nil
-}
 
-func genPRGraph(org string, repo string, pulses []Pulse) error {
+	if config.Settings.SQLite != "" {
+		fmt.Printf("generating sqlite database...\n")
+		err = genSQLite(config, repos, users, top, anon)
+		if err != nil {
+			fmt.Println("Error writing sqlite database:", err)
+			return err
+		}
+	}
 
-	name := fmt.Sprintf("%s-%s-abs.csv", org, repo)
-	f, err := os.Create(name)
-	if err != nil {
-		return fmt.Errorf("cannot create graph file: %w", err)
+	if config.Settings.Influx != "" {
+		fmt.Printf("generating influx line protocol...\n")
+		err = genInflux(config, repos)
+		if err != nil {
+			fmt.Println("Error writing influx line protocol:", err)
+			return err
+		}
 	}
 
-	w := csv.NewWriter(f)
-	w.Write([]string{fmt.Sprintf("Repo: %s/%s", org, repo)})
-	w.Write([]string{
-		"Pulse",
-		"Contributors",
-		"Open",
-		"Merged",
-	})
-	for _, p := range pulses {
+	if config.Settings.Contributors.EmitDetailed {
+		fmt.Printf("generating detailed user list...\n")
+		err = genUsersDetailed(config, details, top, anon)
+		if err != nil {
+			fmt.Println("Error writing detailed users to file:", err)
+			return err
+		}
+	}
 
-		s := p.Start.Format("2006-01-02")
-		w.Write([]string{
-			s,
-			fmt.Sprintf("%d", p.Contributors),
-			fmt.Sprintf("%0.2f", p.PrOpen),
-			fmt.Sprintf("%0.2f", p.PrMerged),
-		})
+	if config.Settings.Contributors.EmitMatrix {
+		fmt.Printf("generating user matrix...\n")
+		err = genUsersMatrix(config, details, top, anon)
+		if err != nil {
+			fmt.Println("Error writing user matrix to file:", err)
+			return err
+		}
+	}
+
+	// Report the changes since the previous run, and save the
+	// state of this run for the next one.
+	custom, err := customMetrics(config)
+	if err != nil {
+		fmt.Println("Error parsing custom metrics:", err)
+		return err
+	}
+	summary := &Summary{
+		Time:  now,
+		Repos: make(map[string]RepoSummary),
+	}
+	for _, k := range config.Repos {
+		s := repos[k].summary
+		s.Metrics = omitMetrics(config, latestMetrics(custom, repos[k].pulses))
+		summary.Repos[k] = s
+	}
+	prevSummary, err := loadSummary(summaryFile)
+	if err != nil {
+		fmt.Println("Error loading previous summary:", err)
+		return err
+	}
+	if prevSummary != nil {
+		fmt.Printf("generating delta since %s...\n", prevSummary.Time.Format("2006-01-02 15:04"))
+		err = genDelta(config, prevSummary, summary, top, anon)
+		if err != nil {
+			fmt.Println("Error writing delta:", err)
+			return err
+		}
+	}
+	if config.Settings.Contributors.EmitNew {
+		fmt.Printf("generating new contributors...\n")
+		err = genNewContributors(config, summary, top, anon)
+		if err != nil {
+			fmt.Println("Error writing new contributors:", err)
+			return err
+		}
+	}
+	err = saveSummary(summaryFile, "summary", summary, anon)
+	if err != nil {
+		fmt.Println("Error saving summary:", err)
+		return err
 	}
-	w.Flush()
-	f.Sync()
-	f.Close()
-	return nil
-}
 
-func genNormGraph(org string, repo string, pulses []Pulse) error {
+	err = anon.Save()
+	if err != nil {
+		fmt.Println("Error saving anonymise mapping:", err)
+		return err
+	}
 
-	name := fmt.Sprintf("%s-%s-norm.csv", org, repo)
-	f, err := os.Create(name)
+	err = events.Close()
+	if err == nil && eventsPath != "" {
+		err = addManifest(eventsPath, "events")
+	}
 	if err != nil {
-		return fmt.Errorf("cannot create graph file: %w", err)
+		fmt.Println("Error writing events file:", err)
+		return err
 	}
 
-	w := csv.NewWriter(f)
-	w.Write([]string{fmt.Sprintf("Repo: %s/%s", org, repo)})
-	w.Write([]string{
-		"Pulse",
-		"Open (Norm)",
-		"Merged (Norm)",
-	})
-	for _, p := range pulses {
+	// The manifest is written last, listing all the files above
+	err = saveManifest(manifestFile)
+	if err != nil {
+		fmt.Println("Error saving manifest:", err)
+		return err
+	}
 
-		s := p.Start.Format("2006-01-02")
-		w.Write([]string{
-			s,
-			fmt.Sprintf("%0.2f", p.PrOpenNorm),
-			fmt.Sprintf("%0.2f", p.PrMergedNorm),
-		})
+	if *noFiles {
+		printLatest(config, repos)
 	}
-	w.Flush()
-	f.Sync()
-	f.Close()
+
+	if *baseline != "" {
+		base, err := loadSummary(*baseline)
+		if err != nil {
+			fmt.Println("Error loading baseline:", err)
+			return err
+		}
+		if base == nil {
+			err = fmt.Errorf("%s not found", *baseline)
+			fmt.Println("Error loading baseline:", err)
+			return err
+		}
+		regressions := checkBaseline(config, base, summary)
+		if len(regressions) > 0 {
+			for _, r := range regressions {
+				fmt.Println("Baseline regression:", r)
+			}
+			return fmt.Errorf("%d baseline regressions", len(regressions))
+		}
+	}
+
+	fmt.Println("done.")
 	return nil
 }
 
-func genUsers(users map[string]User) error {
+// runAuthorReport generates the pulse report of a single contributor
+// over the PRs of all the repos.
+func runAuthorReport(config Config, repos map[string]*Repo, startGraphs time.Time, login string) error {
+	fmt.Printf("%s: generating pulse metrics...\n", login)
 
-	name := fmt.Sprintf("all-users.csv")
-	f, err := os.Create(name)
+	// Restricting the allowlist to the author makes all metrics
+	// only consider the PRs of the author.
+	config.Settings.Contributors.Allowlist = []string{login}
+
+	prs := make([]PrEntry, 0)
+	for _, k := range config.Repos {
+		for _, p := range repos[k].prs {
+			if contributorLogin(config, p) == login {
+				prs = append(prs, p)
+			}
+		}
+	}
+
+	endTime := graphsEnd(config)
+	authorUsers := getUsers(config, prs, nil)
+	pulses := getPulses(config, startGraphs, endTime, prs, authorUsers)
+	err := orderPulses(pulses)
 	if err != nil {
-		return fmt.Errorf("cannot create user list file: %w", err)
+		fmt.Println("Error ordering pulses:", err)
+		return err
 	}
 
-	w := csv.NewWriter(f)
-	w.Write([]string{"Login"})
-	for k, _ := range users {
-		w.Write([]string{k})
+	fmt.Printf("%s: generating author report...\n", login)
+	err = genAuthorReport(config, login, pulses)
+	if err != nil {
+		fmt.Println("Error writing author report:", err)
+		return err
 	}
-	w.Flush()
-	f.Sync()
-	f.Close()
+
+	var open, merged, closed int
+	for _, p := range prs {
+		switch {
+		case p.State == "OPEN":
+			open += 1
+		case p.MergedAt != nil:
+			merged += 1
+		default:
+			closed += 1
+		}
+	}
+	fmt.Printf("%s: %d prs (%d merged, %d closed, %d open)\n", login, len(prs), merged, closed, open)
+
+	fmt.Println("done.")
 	return nil
 }
 
-type Repo struct {
-	start  time.Time
-	prs    []PrEntry
-	pulses []Pulse
+// overrideSettings applies the settings supplied as flags, which take
+// precedence over the config file. Only flags explicitly set on the
+// command line are applied.
+func overrideSettings(config *Config) {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "cooldown":
+			config.Settings.Contributors.Cooldown = *cooldown
+		case "pr-high":
+			config.Settings.PR.High = *prHigh
+		case "pr-low":
+			config.Settings.PR.Low = *prLow
+		case "window-weeks":
+			// The window is in pulses, rounding up to include
+			// every week requested.
+			config.Settings.Graphs.Window = (*windowWeeks + 1) / 2
+			if config.Settings.Graphs.Cadence == "month" {
+				config.Settings.Graphs.Window = (*windowWeeks*12 + 51) / 52
+			}
+		}
+	})
 }
 
-type PrEntry struct {
-	Additions int
-	ClosedAt  *time.Time
-	CreatedAt time.Time
-	MergedAt  *time.Time
-	Deletions int
-	State     string
-	BaseRefName string
-	Author    struct {
-		Login string
+// graphsEnd returns the end of the graphs, which is the current time
+// padded by Graphs.EndPadding days (one day by default).
+func graphsEnd(config Config) time.Time {
+	pad := 1
+	if config.Settings.Graphs.EndPadding != nil {
+		pad = *config.Settings.Graphs.EndPadding
 	}
+	return now.AddDate(0, 0, pad)
 }
 
-type RepoEntry struct {
-	Repository struct {
-		DefaultBranchRef struct {
-			Name string
+// readReposFile adds the repos listed in the repos file to the repos of
+// the config. The file has a repo (org/repo) per line. Blank lines and
+// anything following a # are ignored.
+func readReposFile(config *Config) error {
+	if config.ReposFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(config.ReposFile)
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line != "" {
+			config.Repos = append(config.Repos, line)
+		}
+	}
+	return nil
+}
+
+// disableHistoryFeatures turns off the features relying on the first PR
+// of each contributor when only the recently updated PRs are read, as
+// User.Start is then the first PR read rather than the first PR of the
+// contributor. Every contributor would otherwise look new.
+func disableHistoryFeatures(config *Config) {
+	if !config.Settings.Fetch.UpdatedOnly {
+		return
+	}
+	if config.Settings.Contributors.EmitNew {
+		fmt.Printf("warning: updated only does not read the first PR of each contributor, disabling new contributors\n")
+		config.Settings.Contributors.EmitNew = false
+	}
+	if config.Settings.Contributors.NormMode == "recency" {
+		fmt.Printf("warning: updated only does not read the first PR of each contributor, disabling the recency norm mode\n")
+		config.Settings.Contributors.NormMode = ""
+	}
+}
+
+// dedupeRepos removes repos listed more than once, ignoring case, so
+// they are not scanned twice. The first occurrence of each repo is kept,
+// preserving the order of the list.
+func dedupeRepos(config *Config) {
+	seen := make(map[string]bool)
+	repos := make([]string, 0, len(config.Repos))
+	for _, k := range config.Repos {
+		org, repo, err := orgRepoSplit(k)
+		if err != nil {
+			// Reported when the repo is read
+			repos = append(repos, k)
+			continue
+		}
+		key := strings.ToLower(org + "/" + repo)
+		if seen[key] {
+			fmt.Printf("%s: warning: repo listed more than once, ignoring duplicate\n", k)
+			continue
+		}
+		seen[key] = true
+		repos = append(repos, k)
+	}
+	config.Repos = repos
+}
+
+// validateConfig checks the settings for invalid values. Settings that
+// are valid but likely a mistake only produce a warning.
+func validateConfig(config Config) error {
+	high := config.Settings.PR.High
+	low := config.Settings.PR.Low
+	if high <= 0 || low <= 0 {
+		fmt.Printf("warning: pr high (%d) and low (%d) thresholds should both be set, otherwise normalisation weighs most PRs the same\n", high, low)
+	} else if high <= low {
+		fmt.Printf("warning: pr high threshold (%d) should be above the low threshold (%d), otherwise normalisation never applies the 2x weight\n", high, low)
+	}
+	if mega := config.Settings.PR.MegaLines; mega > 0 && high > 0 && mega <= high {
+		fmt.Printf("warning: mega lines (%d) should be above the pr high threshold (%d), otherwise every large PR is counted as a mega PR\n", mega, high)
+	}
+	if age, stale := config.Settings.PR.MaxOpenAge, config.Settings.PR.StaleDays; age > 0 && stale > 0 && age <= stale {
+		fmt.Printf("warning: max open age days (%d) should be above the stale days (%d), otherwise every stale PR is counted as abandoned and the stale count is always zero\n", age, stale)
+	}
+
+	_, err := prStates(config)
+	if err != nil {
+		return err
+	}
+
+	switch config.Settings.Graphs.CompareSort {
+	case "", "config", "value":
+	default:
+		return fmt.Errorf("unknown compare sort %q", config.Settings.Graphs.CompareSort)
+	}
+
+	for _, s := range config.Searches {
+		if s.Name == "" || strings.Contains(s.Name, "/") {
+			return fmt.Errorf("invalid search name %q", s.Name)
+		}
+		if s.Query == "" {
+			return fmt.Errorf("search %q has no query", s.Name)
+		}
+	}
+
+	_, err = customMetrics(config)
+	if err != nil {
+		return err
+	}
+
+	err = validateBaseline(config)
+	if err != nil {
+		return err
+	}
+	err = validateThresholds(config)
+	if err != nil {
+		return err
+	}
+	err = validateOmitFields(config)
+	if err != nil {
+		return err
+	}
+
+	if p := config.Settings.Graphs.Precision; p != nil && (*p < 0 || *p > 6) {
+		return fmt.Errorf("precision %d must be between 0 and 6", *p)
+	}
+
+	if p := config.Settings.Graphs.EndPadding; p != nil && *p < 0 {
+		return fmt.Errorf("end padding %d days must not be negative", *p)
+	}
+
+	if tz := config.Settings.Graphs.ReportTZ; tz != "" {
+		_, err := time.LoadLocation(tz)
+		if err != nil {
+			return fmt.Errorf("invalid report timezone %q: %w", tz, err)
+		}
+	}
+
+	switch config.Settings.Contributors.Field {
+	case "", "author", "merged_by", "assignee":
+	default:
+		return fmt.Errorf("unknown contributor field %q", config.Settings.Contributors.Field)
+	}
+
+	if config.Settings.Contributors.MinPRs < 0 {
+		return fmt.Errorf("min prs for contributor must not be negative")
+	}
+
+	switch config.Settings.Contributors.NormMode {
+	case "", "count":
+	case "recency":
+		if config.Settings.Contributors.HalfLife <= 0 {
+			return fmt.Errorf("the recency norm mode requires a positive half life")
+		}
+	default:
+		return fmt.Errorf("unknown norm mode %q", config.Settings.Contributors.NormMode)
+	}
+
+	switch config.Settings.Contributors.Basis {
+	case "", "active", "raw":
+	default:
+		return fmt.Errorf("unknown contributor basis %q", config.Settings.Contributors.Basis)
+	}
+
+	switch config.Settings.Graphs.WindowBasis {
+	case "", "activity", "created", "merged":
+	default:
+		return fmt.Errorf("unknown window basis %q", config.Settings.Graphs.WindowBasis)
+	}
+
+	if w := config.Settings.PR.AdditionsWeight; w != nil && *w < 0 {
+		return fmt.Errorf("additions weight %g must not be negative", *w)
+	}
+	if w := config.Settings.PR.DeletionsWeight; w != nil && *w < 0 {
+		return fmt.Errorf("deletions weight %g must not be negative", *w)
+	}
+	for label, w := range config.Settings.PR.LabelWeights {
+		if w < 0 {
+			return fmt.Errorf("label weight %g of %q must not be negative", w, label)
+		}
+	}
+
+	switch config.Settings.PR.LineSource {
+	case "", "pr", "commits":
+	default:
+		return fmt.Errorf("unknown line source %q", config.Settings.PR.LineSource)
+	}
+
+	if u := config.Settings.Fetch.APIURL; u != "" {
+		parsed, err := url.Parse(u)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("api url %q must be an http or https url", u)
+		}
+	}
+
+	switch config.Settings.Fetch.APIMode {
+	case "", "graphql":
+	case "rest":
+		if config.Settings.Fetch.Streaming {
+			return fmt.Errorf("streaming requires the graphql api mode")
+		}
+		if config.Settings.PR.Approvals > 0 {
+			fmt.Printf("warning: the rest api mode does not read reviews, so all merged PRs are counted as unapproved\n")
+		}
+		if config.Settings.Contributors.EmailDomains {
+			fmt.Printf("warning: the rest api mode does not read commits, so all PRs are counted in the (unknown) email domain\n")
+		}
+		if config.Settings.PR.ReviewTurnaround {
+			return fmt.Errorf("reviewer turnaround requires the graphql api mode")
+		}
+	default:
+		return fmt.Errorf("unknown api mode %q", config.Settings.Fetch.APIMode)
+	}
+
+	if config.Settings.Contributors.ExcludeMembers && config.Settings.Fetch.APIMode == "rest" {
+		return fmt.Errorf("excluding org members reads the members from the graphql api, which the rest api mode does not use")
+	}
+
+	if config.Settings.PR.ReviewTurnaround && config.Settings.Fetch.Streaming {
+		return fmt.Errorf("reviewer turnaround requires the PRs to be retained, which streaming does not")
+	}
+
+	if config.Settings.Fetch.UpdatedOnly {
+		if config.Settings.Graphs.Start == nil {
+			return fmt.Errorf("updated only requires a graph start")
+		}
+		_, err := parseStart(*config.Settings.Graphs.Start, now)
+		if err != nil {
+			return fmt.Errorf("invalid graph start: %w", err)
+		}
+	}
+	if config.Settings.PR.MaxOpenAge < 0 {
+		return fmt.Errorf("max open age days must not be negative")
+	}
+	if config.Settings.PR.MegaLines < 0 {
+		return fmt.Errorf("mega lines must not be negative")
+	}
+	if config.Settings.PR.EmitMegaPRs && config.Settings.PR.MegaLines == 0 {
+		return fmt.Errorf("emitting mega prs requires mega lines")
+	}
+	if config.Settings.Fetch.LookbackDays < 0 {
+		return fmt.Errorf("lookback days must not be negative")
+	}
+	if config.Settings.Fetch.SeedDays < 0 {
+		return fmt.Errorf("contributor lookback days must not be negative")
+	}
+
+	for login, period := range config.Settings.Contributors.Periods {
+		for _, d := range []string{period.From, period.Until} {
+			if d == "" {
+				continue
+			}
+			_, err := time.Parse("2006-01-02", d)
+			if err != nil {
+				return fmt.Errorf("invalid allowlist period of %s: %w", login, err)
+			}
+		}
+	}
+
+	for _, h := range config.Settings.PR.Holidays {
+		_, err := time.Parse("2006-01-02", h)
+		if err != nil {
+			return fmt.Errorf("invalid holiday: %w", err)
+		}
+	}
+
+	for k, releases := range config.Releases {
+		for _, r := range releases {
+			_, err := time.Parse("2006-01-02", r.Date)
+			if err != nil {
+				return fmt.Errorf("invalid date of release %q of %s: %w", r.Tag, k, err)
+			}
+		}
+	}
+
+	switch config.Settings.Graphs.Cadence {
+	case "", "pulse", "month":
+	default:
+		return fmt.Errorf("unknown cadence %q", config.Settings.Graphs.Cadence)
+	}
+
+	basis := config.Settings.Graphs.WindowBasis
+	if config.Settings.Graphs.MergeOnce && basis != "" && basis != "merged" {
+		return fmt.Errorf("merge once conflicts with window basis %q", config.Settings.Graphs.WindowBasis)
+	}
+
+	return nil
+}
+
+// parseStart parses the graph start date, which is either an absolute
+// date (2006-01-02) or a duration relative to now such as -6m, -1y, -52w
+// or -30d.
+func parseStart(value string, now time.Time) (time.Time, error) {
+	if !strings.HasPrefix(value, "-") {
+		return time.Parse("2006-01-02", value)
+	}
+
+	if len(value) < 3 {
+		return time.Time{}, fmt.Errorf("invalid relative start %q", value)
+	}
+	n, err := strconv.Atoi(value[1 : len(value)-1])
+	if err != nil || n < 0 {
+		return time.Time{}, fmt.Errorf("invalid relative start %q", value)
+	}
+
+	switch value[len(value)-1] {
+	case 'y':
+		return now.AddDate(-n, 0, 0), nil
+	case 'm':
+		return now.AddDate(0, -n, 0), nil
+	case 'w':
+		return now.AddDate(0, 0, -7*n), nil
+	case 'd':
+		return now.AddDate(0, 0, -n), nil
+	default:
+		return time.Time{}, fmt.Errorf("invalid relative start %q: unit must be one of y, m, w or d", value)
+	}
+}
+
+// printLatest prints the main metrics of the latest pulse of each repo,
+// as a compact summary when no files are written.
+func printLatest(config Config, repos map[string]*Repo) {
+	for _, k := range config.Repos {
+		pulses := repos[k].pulses
+		if len(pulses) == 0 {
+			continue
+		}
+		p := pulses[len(pulses)-1]
+		fmt.Printf("%s: pulse %s: %d contributors, %s open, %s merged, %s closed, %s merged (norm)\n",
+			k, pulseDate(config, p.Start), p.Contributors,
+			formatFloat(config, float64(p.PrOpen)),
+			formatFloat(config, float64(p.PrMerged)),
+			formatFloat(config, float64(p.PrClosed)),
+			formatFloat(config, float64(p.PrMergedNorm)))
+	}
+}
+
+// writeFile creates the named file in the current directory and writes
+// it using write. The kind of file is only used in error messages. With
+// --no-files nothing is written.
+func writeFile(name string, kind string, write func(out io.Writer) error) error {
+	if *noFiles {
+		return nil
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("cannot create %s file: %w", kind, err)
+	}
+	err = write(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write %s file: %w", kind, err)
+	}
+	return addManifest(name, kind)
+}
+
+func genCompareNormGraphs(config Config, repos map[string]*Repo) error {
+	graphs := []struct {
+		name string
+		desc string
+	}{
+		{
+			name: "open",
+			desc: "open (norm)",
+		},
+		{
+			name: "merged",
+			desc: "merged (norm)",
+		},
+	}
+
+	for _, t := range graphs {
+
+		fmt.Printf("%s: generating normalised comparison graph...\n", t.desc)
+
+		name := fmt.Sprintf("compare-%s.csv", t.name)
+		err := writeFile(name, "comparison graph", func(out io.Writer) error {
+			return writeCompareGraph(out, config, repos, t.name, t.desc)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// genCompareAbsGraphs writes the comparison graphs of the absolute open
+// and merged PRs, which suit repos with similar team sizes.
+func genCompareAbsGraphs(config Config, repos map[string]*Repo) error {
+	graphs := []struct {
+		name string
+		desc string
+	}{
+		{
+			name: "open-abs",
+			desc: "open",
+		},
+		{
+			name: "merged-abs",
+			desc: "merged",
+		},
+	}
+
+	for _, t := range graphs {
+
+		fmt.Printf("%s: generating absolute comparison graph...\n", t.desc)
+
+		name := fmt.Sprintf("compare-%s.csv", t.name)
+		err := writeFile(name, "comparison graph", func(out io.Writer) error {
+			return writeCompareGraph(out, config, repos, t.name, t.desc)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCompareGraph writes the comparison graph of the metric t to out.
+func writeCompareGraph(out io.Writer, config Config, repos map[string]*Repo, t string, desc string) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{fmt.Sprintf("Compare: %s", desc)})
+
+	for i, k := range compareOrder(config, repos, t) {
+		if i == 0 {
+			// The first iteration needs to plot the dates
+			line := make([]string, 0)
+			line = append(line, "Pulse")
+			for _, v := range repos[k].pulses {
+				line = append(line, pulseDate(config, v.Start))
+			}
+			w.Write(line)
+			if index := pulseIndexRow(config, repos[k].pulses); index != nil {
+				w.Write(index)
+			}
+		}
+
+		line := make([]string, 0)
+		line = append(line, k)
+		for _, v := range repos[k].pulses {
+			line = append(line, formatFloat(config, float64(compareValue(t, v))))
+		}
+		w.Write(line)
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+func compareValue(t string, p Pulse) float32 {
+	switch t {
+	case "open":
+		return p.PrOpenNorm
+	case "merged":
+		return p.PrMergedNorm
+	case "open-abs":
+		return p.PrOpen
+	case "merged-abs":
+		return p.PrMerged
+	default:
+		panic("not a valid metric type")
+	}
+}
+
+// compareOrder returns the order of the repos in a comparison graph. By
+// default the config order is used. If Graphs.CompareSort is "value",
+// repos are sorted by the metric value of their latest pulse, highest
+// first. Repos missing from repos, because their scan did not complete,
+// are left out with a warning.
+func compareOrder(config Config, repos map[string]*Repo, t string) []string {
+	order := make([]string, 0, len(config.Repos))
+	for _, k := range config.Repos {
+		if repos[k] == nil {
+			fmt.Printf("%s: warning: repo was not scanned, leaving it out of the %s comparison\n", k, t)
+			continue
+		}
+		order = append(order, k)
+	}
+	if config.Settings.Graphs.CompareSort != "value" {
+		return order
+	}
+
+	latest := func(k string) float32 {
+		pulses := repos[k].pulses
+		if len(pulses) == 0 {
+			return 0.0
+		}
+		return compareValue(t, pulses[len(pulses)-1])
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return latest(order[i]) > latest(order[j])
+	})
+	return order
+}
+
+// genContributorHeatmap writes a contributor x pulse matrix where each
+// cell is the number of PRs the contributor merged in that pulse, summed
+// over all configured repos. All repos share the same pulse layout, so
+// the pulse dates are taken from the first repo.
+func genContributorHeatmap(config Config, repos map[string]*Repo, top map[string]bool, anon *Anonymiser) error {
+	if len(config.Repos) == 0 {
+		return nil
+	}
+	pulses := repos[config.Repos[0]].pulses
+
+	merged := make(map[string][]int)
+	for _, k := range config.Repos {
+		for i, p := range repos[k].pulses {
+			for login, count := range p.AuthorMerged {
+				login = anon.Name(topLogin(top, login))
+				if _, ok := merged[login]; !ok {
+					merged[login] = make([]int, len(pulses))
+				}
+				merged[login][i] += count
+			}
+		}
+	}
+
+	logins := make([]string, 0, len(merged))
+	for login := range merged {
+		if login != othersLogin {
+			logins = append(logins, login)
+		}
+	}
+	sort.Strings(logins)
+	if _, ok := merged[othersLogin]; ok {
+		// The aggregated bucket always goes last
+		logins = append(logins, othersLogin)
+	}
+
+	return writeFile("contributor-heatmap.csv", "heatmap", func(out io.Writer) error {
+		return writeContributorHeatmap(out, config, pulses, logins, merged)
+	})
+}
+
+func genPerContributorThroughput(config Config, repos map[string]*Repo, top map[string]bool, anon *Anonymiser) error {
+	return writeFile("contributor-throughput.csv", "throughput", func(out io.Writer) error {
+		return writePerContributorThroughput(out, config, repos, top, anon)
+	})
+}
+
+// writePerContributorThroughput writes the merged PRs per active pulse of
+// each contributor of each repo to out. A contributor is active in the
+// pulses they are counted as a contributor of the repo, so the throughput
+// shows the spread behind the normalised values. The contributors outside
+// the top contributors share a row, with their merged PRs and active
+// pulses summed.
+func writePerContributorThroughput(out io.Writer, config Config, repos map[string]*Repo, top map[string]bool, anon *Anonymiser) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"Repo", "Login", "Merged", "Active Pulses", "Merged per Active Pulse"})
+	for _, k := range config.Repos {
+		merged := make(map[string]int)
+		active := make(map[string]int)
+		for _, p := range repos[k].pulses {
+			for login, count := range p.AuthorMerged {
+				merged[topLogin(top, login)] += count
+			}
+			for login := range p.Active {
+				active[topLogin(top, login)] += 1
+			}
+		}
+
+		logins := make([]string, 0, len(active))
+		for login := range active {
+			logins = append(logins, login)
+		}
+		for login := range merged {
+			if _, ok := active[login]; !ok {
+				logins = append(logins, login)
+			}
+		}
+		sortOthersLast(logins)
+
+		for _, login := range logins {
+			// No value without active pulses
+			rate := ""
+			if active[login] > 0 {
+				rate = formatFloat(config, float64(merged[login])/float64(active[login]))
+			}
+			w.Write([]string{
+				k,
+				anon.Name(login),
+				fmt.Sprintf("%d", merged[login]),
+				fmt.Sprintf("%d", active[login]),
+				rate,
+			})
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// writeContributorHeatmap writes the heatmap to out.
+func writeContributorHeatmap(out io.Writer, config Config, pulses []Pulse, logins []string, merged map[string][]int) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"Contributor heatmap: merged"})
+
+	line := make([]string, 0)
+	line = append(line, "Login")
+	for _, p := range pulses {
+		line = append(line, pulseDate(config, p.Start))
+	}
+	w.Write(line)
+	if index := pulseIndexRow(config, pulses); index != nil {
+		w.Write(index)
+	}
+
+	for _, login := range logins {
+		line := make([]string, 0)
+		line = append(line, login)
+		for _, count := range merged[login] {
+			line = append(line, fmt.Sprintf("%d", count))
+		}
+		w.Write(line)
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+// othersLogin is the aggregated bucket used for contributors outside
+// the top contributors when Contributors.TopContributors is set.
+const othersLogin = "(others)"
+
+// topLogin returns the login under which the contributor is reported,
+// which is othersLogin for the contributors outside the top set.
+func topLogin(top map[string]bool, login string) string {
+	if top != nil && !top[login] {
+		return othersLogin
+	}
+	return login
+}
+
+// sortOthersLast sorts the logins, with the aggregated bucket last.
+func sortOthersLast(logins []string) {
+	sort.Slice(logins, func(i, j int) bool {
+		if logins[i] == othersLogin || logins[j] == othersLogin {
+			return logins[j] == othersLogin && logins[i] != othersLogin
+		}
+		return logins[i] < logins[j]
+	})
+}
+
+// topContributors ranks contributors by their total merged PRs across
+// all repos and returns the set of the top N logins. A nil set is
+// returned if the top contributors restriction is disabled.
+func topContributors(config Config, repos map[string]*Repo) map[string]bool {
+	n := config.Settings.Contributors.TopContributors
+	if n <= 0 {
+		return nil
+	}
+
+	totals := make(map[string]int)
+	for _, k := range config.Repos {
+		for _, p := range repos[k].pulses {
+			for login, count := range p.AuthorMerged {
+				totals[login] += count
+			}
+		}
+	}
+
+	logins := make([]string, 0, len(totals))
+	for login := range totals {
+		logins = append(logins, login)
+	}
+	sort.Slice(logins, func(i, j int) bool {
+		if totals[logins[i]] != totals[logins[j]] {
+			return totals[logins[i]] > totals[logins[j]]
+		}
+		return logins[i] < logins[j]
+	})
+
+	top := make(map[string]bool)
+	for i, login := range logins {
+		if i >= n {
+			break
+		}
+		top[login] = true
+	}
+	return top
+}
+
+// formatFloat formats a metric value using the number of decimal places
+// configured by Graphs.Precision (2 by default).
+func formatFloat(config Config, v float64) string {
+	precision := 2
+	if config.Settings.Graphs.Precision != nil {
+		precision = *config.Settings.Graphs.Precision
+	}
+	return strconv.FormatFloat(v, 'f', precision, 64)
+}
+
+// pulseColumns returns the header of the columns identifying a pulse in
+// the per-repo graphs.
+func pulseColumns(config Config) []string {
+	if config.Settings.Graphs.PulseIndex {
+		return []string{"Pulse", "Index"}
+	}
+	return []string{"Pulse"}
+}
+
+// pulseLabels returns the values of the columns identifying a pulse in
+// the per-repo graphs. The pulse index counts from 0 for each repo.
+func pulseLabels(config Config, i int, p Pulse) []string {
+	if config.Settings.Graphs.PulseIndex {
+		return []string{pulseDate(config, p.Start), fmt.Sprintf("%d", i)}
+	}
+	return []string{pulseDate(config, p.Start)}
+}
+
+// pulseDate formats the start of a pulse for the graphs, in the report
+// timezone if configured. Month pulses are calendar months in the report
+// timezone, but 2-week pulses are always in UTC, so only their labels
+// use the report timezone.
+func pulseDate(config Config, t time.Time) string {
+	if config.Settings.Graphs.Cadence == "month" {
+		return t.In(reportLocation(config)).Format("2006-01")
+	}
+	return t.In(reportLocation(config)).Format("2006-01-02")
+}
+
+// reportLocation returns the report timezone, or UTC if not configured.
+func reportLocation(config Config) *time.Location {
+	if tz := config.Settings.Graphs.ReportTZ; tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err == nil {
+			return loc
+		}
+	}
+	return time.UTC
+}
+
+// pulseIndexRow returns the row of pulse indices for graphs which plot
+// pulses as columns, or nil if the pulse index is disabled.
+func pulseIndexRow(config Config, pulses []Pulse) []string {
+	if !config.Settings.Graphs.PulseIndex {
+		return nil
+	}
+	line := make([]string, 0)
+	line = append(line, "Index")
+	for i := range pulses {
+		line = append(line, fmt.Sprintf("%d", i))
+	}
+	return line
+}
+
+func genReleases(config Config, org string, repo string, releases []Release, pulses []Pulse) error {
+	name := fmt.Sprintf("%s-%s-releases.csv", org, repo)
+	return writeFile(name, "releases", func(out io.Writer) error {
+		return writeReleases(out, config, releases, pulses)
+	})
+}
+
+// writeReleases writes the releases of a repo, with the pulse each
+// release falls in, to out. The pulse is left empty for releases outside
+// of the graphs.
+func writeReleases(out io.Writer, config Config, releases []Release, pulses []Pulse) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"Tag", "Date", "Pulse", "Index"})
+	for _, r := range releases {
+		date, err := time.Parse("2006-01-02", r.Date)
+		if err != nil {
+			return err
+		}
+		line := []string{r.Tag, r.Date, "", ""}
+		if i := windowIndex(pulses, date); i >= 0 {
+			line[2] = pulseDate(config, pulses[i].Start)
+			line[3] = fmt.Sprintf("%d", i)
+		}
+		w.Write(line)
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func genAudit(config Config, org string, repo string, pulses []Pulse, top map[string]bool, anon *Anonymiser) error {
+	name := fmt.Sprintf("%s-%s-audit.csv", org, repo)
+	return writeFile(name, "audit", func(out io.Writer) error {
+		return writeAudit(out, config, pulses, top, anon)
+	})
+}
+
+// writeAudit writes a row per PR counted in each pulse to out, with the
+// contributor of the PR and its classification in the pulse metrics. PRs
+// without a contributor have an empty contributor.
+func writeAudit(out io.Writer, config Config, pulses []Pulse, top map[string]bool, anon *Anonymiser) error {
+	w := csv.NewWriter(out)
+	w.Write(append(pulseColumns(config), "PR", "Contributor", "Classification"))
+	for i, p := range pulses {
+		for _, pull := range p.Pulls {
+			contributor := ""
+			if pull.Contributor != "" {
+				contributor = anon.Name(topLogin(top, pull.Contributor))
+			}
+			w.Write(append(pulseLabels(config, i, p), fmt.Sprintf("%d", pull.Number), contributor, pullClass(pull)))
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func genPRGraph(config Config, org string, repo string, pulses []Pulse) error {
+
+	custom, err := customMetrics(config)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s-abs.csv", org, repo)
+	if *appendMode && !*noFiles {
+		rows, err := readPRGraph(name, config, custom)
+		if err != nil {
+			return err
+		}
+		if rows != nil {
+			return writeFile(name, "abs graph", func(out io.Writer) error {
+				return appendPRGraph(out, config, custom, rows, pulses)
+			})
+		}
+	}
+	return writeFile(name, "abs graph", func(out io.Writer) error {
+		return writePRGraph(out, config, custom, org, repo, pulses)
+	})
+}
+
+// readPRGraph returns the rows of an existing PR graph file to append
+// to, without the trailing rows of partial pulses, which are written
+// again with their current values. If the file does not exist, nil is
+// returned so the graph is written as usual.
+func readPRGraph(name string, config Config, custom []CustomMetric) ([][]string, error) {
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read graph file: %w", err)
+	}
+	r := csv.NewReader(f)
+	// The title row has a single field
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read graph file %s: %w", name, err)
+	}
+	header := prGraphHeader(config, custom)
+	if len(rows) < 2 || strings.Join(rows[1], ",") != strings.Join(header, ",") {
+		return nil, fmt.Errorf("cannot append to %s, as its columns differ from the current graph", name)
+	}
+
+	partial := 0
+	for i, column := range header {
+		if column == "Partial" {
+			partial = i
+		}
+	}
+	for len(rows) > 2 && rows[len(rows)-1][partial] == "true" {
+		rows = rows[:len(rows)-1]
+	}
+	return rows, nil
+}
+
+// appendPRGraph writes the rows of an existing PR graph to out, followed
+// by the pulses after its last pulse. The rows already in the graph are
+// left as they are, and the pulse index continues from the last index
+// in the graph.
+func appendPRGraph(out io.Writer, config Config, custom []CustomMetric, rows [][]string, pulses []Pulse) error {
+	// Labels sort chronologically, so later pulses have greater labels
+	first := 0
+	offset := 0
+	if len(rows) > 2 {
+		last := rows[len(rows)-1]
+		for first < len(pulses) && pulseDate(config, pulses[first].Start) <= last[0] {
+			first += 1
+		}
+		if config.Settings.Graphs.PulseIndex {
+			index, err := strconv.Atoi(last[1])
+			if err != nil {
+				return fmt.Errorf("invalid pulse index %q in graph: %w", last[1], err)
+			}
+			offset = index + 1 - first
+		}
+	}
+
+	w := csv.NewWriter(out)
+	w.WriteAll(rows)
+	writePRGraphRows(w, config, custom, pulses, first, offset)
+	w.Flush()
+	return w.Error()
+}
+
+// writePRGraph writes the PR graph to out.
+func writePRGraph(out io.Writer, config Config, custom []CustomMetric, org string, repo string, pulses []Pulse) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{fmt.Sprintf("Repo: %s/%s", org, repo)})
+	w.Write(prGraphHeader(config, custom))
+	writePRGraphRows(w, config, custom, pulses, 0, 0)
+	w.Flush()
+	return w.Error()
+}
+
+// prGraphHeader returns the column names of the PR graph.
+func prGraphHeader(config Config, custom []CustomMetric) []string {
+	header := append(pulseColumns(config),
+		"Contributors",
+		"Open",
+		"Merged",
+	)
+	// Without required approvals no PR is unapproved
+	if config.Settings.PR.Approvals > 0 {
+		header = append(header, "Merged (Unapproved)")
+	}
+	header = append(header,
+		"Acceptance",
+		"Files Changed (Avg)",
+		"Reverts",
+		"Approval Hours (Median)",
+		"Queue Hours (Median)",
+		"Stale",
+		"Review Comments (Avg)",
+		"Merged (Self)",
+		"Merged (Other)",
+		"Self Merge Rate",
+		"Issues Closed",
+		"Retention",
+		"Short Descriptions",
+		"Description Length (Avg)",
+		"Merge Hours (Median)",
+		"Contributors (Internal)",
+		"Contributors (External)",
+		"Merged (Small)",
+		"Merged (Medium)",
+		"Merged (Large)",
+		"Abandoned",
+		"Mega PRs",
+		"Partial",
+	)
+	for _, m := range custom {
+		header = append(header, m.Name)
+	}
+	return header
+}
+
+// writePRGraphRows writes the rows of the pulses from index first on to
+// the PR graph, with offset added to their pulse index. Earlier pulses
+// are only used for the retention.
+func writePRGraphRows(w *csv.Writer, config Config, custom []CustomMetric, pulses []Pulse, first int, offset int) {
+	for i := first; i < len(pulses); i++ {
+		p := pulses[i]
+
+		// No value if no PRs reached a terminal state
+		acceptance := ""
+		if p.PrMerged+p.PrClosed != 0 {
+			acceptance = formatFloat(config, float64(p.AcceptanceRate))
+		}
+
+		// No value without contributors in the previous pulse
+		retention := ""
+		if i > 0 && len(pulses[i-1].Active) > 0 {
+			retention = formatFloat(config, float64(p.Retention))
+		}
+
+		// No value if the merger of no PR is known
+		selfMerge := ""
+		if p.PrMergedSelf+p.PrMergedOther != 0 {
+			selfMerge = formatFloat(config, float64(p.SelfMergeRate))
+		}
+
+		line := append(pulseLabels(config, i+offset, p),
+			fmt.Sprintf("%d", p.Contributors),
+			formatFloat(config, float64(p.PrOpen)),
+			formatFloat(config, float64(p.PrMerged)),
+		)
+		if config.Settings.PR.Approvals > 0 {
+			line = append(line, metricValue(config, "merged_unapproved", formatFloat(config, float64(p.PrMergedUnapproved))))
+		}
+		line = append(line,
+			acceptance,
+			formatFloat(config, float64(p.AvgFilesChanged)),
+			formatFloat(config, float64(p.Reverts)),
+			metricValue(config, "approval_hours", formatFloat(config, float64(p.ApprovalHours))),
+			formatFloat(config, float64(p.QueueHours)),
+			fmt.Sprintf("%d", p.StalePRs),
+			metricValue(config, "review_comments_avg", formatFloat(config, float64(p.ReviewComments))),
+			metricValue(config, "merged_self", formatFloat(config, float64(p.PrMergedSelf))),
+			metricValue(config, "merged_other", formatFloat(config, float64(p.PrMergedOther))),
+			metricValue(config, "self_merge_rate", selfMerge),
+			formatFloat(config, float64(p.IssuesClosed)),
+			retention,
+			formatFloat(config, float64(p.ShortBodies)),
+			formatFloat(config, float64(p.AvgBodyLength)),
+			formatFloat(config, float64(p.MergeHours)),
+			fmt.Sprintf("%d", p.ContribInternal),
+			fmt.Sprintf("%d", p.ContribExternal),
+			formatFloat(config, float64(p.MergedSmall)),
+			formatFloat(config, float64(p.MergedMedium)),
+			formatFloat(config, float64(p.MergedLarge)),
+			fmt.Sprintf("%d", p.AbandonedOpen),
+			formatFloat(config, float64(p.MegaPRs)),
+			strconv.FormatBool(p.Partial),
+		)
+		values := pulseMetricValues(p)
+		for _, m := range custom {
+			line = append(line, formatFloat(config, m.Expr.Eval(values)))
+		}
+		w.Write(line)
+	}
+}
+
+func genNormGraph(config Config, org string, repo string, pulses []Pulse) error {
+
+	name := fmt.Sprintf("%s-%s-norm.csv", org, repo)
+	return writeFile(name, "norm graph", func(out io.Writer) error {
+		return writeNormGraph(out, config, org, repo, pulses)
+	})
+}
+
+// writeNormGraph writes the normalised graph to out.
+func writeNormGraph(out io.Writer, config Config, org string, repo string, pulses []Pulse) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{fmt.Sprintf("Repo: %s/%s", org, repo)})
+	w.Write(append(pulseColumns(config),
+		"Open (Norm)",
+		"Merged (Norm)",
+		"Divisor",
+		"Partial",
+	))
+	for i, p := range pulses {
+
+		w.Write(append(pulseLabels(config, i, p),
+			formatFloat(config, float64(p.PrOpenNorm)),
+			formatFloat(config, float64(p.PrMergedNorm)),
+			formatFloat(config, float64(p.NormDivisor)),
+			strconv.FormatBool(p.Partial),
+		))
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// genAuthorReport writes the pulses of a single contributor, followed
+// by the totals over all pulses.
+func genAuthorReport(config Config, login string, pulses []Pulse) error {
+
+	name := fmt.Sprintf("%s-pulses.csv", login)
+	return writeFile(name, "author report", func(out io.Writer) error {
+		return writeAuthorReport(out, config, login, pulses)
+	})
+}
+
+// writeAuthorReport writes the author report to out.
+func writeAuthorReport(out io.Writer, config Config, login string, pulses []Pulse) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{fmt.Sprintf("Author: %s", login)})
+	w.Write(append(pulseColumns(config),
+		"Open",
+		"Merged",
+		"Closed",
+	))
+	var merged, closed float32
+	for i, p := range pulses {
+		merged += p.PrMerged
+		closed += p.PrClosed
+		w.Write(append(pulseLabels(config, i, p),
+			formatFloat(config, float64(p.PrOpen)),
+			formatFloat(config, float64(p.PrMerged)),
+			formatFloat(config, float64(p.PrClosed)),
+		))
+	}
+	total := append(make([]string, 0), "Total")
+	for len(total) < len(pulseColumns(config)) {
+		total = append(total, "")
+	}
+	w.Write(append(total,
+		"",
+		formatFloat(config, float64(merged)),
+		formatFloat(config, float64(closed)),
+	))
+	w.Flush()
+	return w.Error()
+}
+
+func genUsers(users map[string]User, top map[string]bool, anon *Anonymiser) error {
+
+	name := fmt.Sprintf("all-users.csv")
+	return writeFile(name, "user list", func(out io.Writer) error {
+		return writeUsers(out, users, top, anon)
+	})
+}
+
+// writeUsers writes the user list to out. The users outside the top
+// contributors are listed once, as the aggregated bucket.
+func writeUsers(out io.Writer, users map[string]User, top map[string]bool, anon *Anonymiser) error {
+	seen := make(map[string]bool)
+	logins := make([]string, 0, len(users))
+	for k := range users {
+		login := topLogin(top, k)
+		if !seen[login] {
+			seen[login] = true
+			logins = append(logins, login)
+		}
+	}
+	sortOthersLast(logins)
+
+	w := csv.NewWriter(out)
+	w.Write([]string{"Login"})
+	for _, login := range logins {
+		w.Write([]string{anon.Name(login)})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// UserDetail records the contribution of a user across all repos.
+type UserDetail struct {
+	Repos   []string       // Keys of the repos contributed to, in config order
+	PRs     int            // Total PRs over all repos
+	RepoPRs map[string]int // PRs per repo key
+}
+
+func genUsersDetailed(config Config, details map[string]*UserDetail, top map[string]bool, anon *Anonymiser) error {
+	return writeFile("all-users-detailed.csv", "detailed user list", func(out io.Writer) error {
+		return writeUsersDetailed(out, config, details, top, anon)
+	})
+}
+
+// writeUsersDetailed writes the users with the repos they contributed
+// to, and their total PRs, to out. The users outside the top
+// contributors share a row, with the repos any of them contributed to
+// and their PRs summed.
+func writeUsersDetailed(out io.Writer, config Config, details map[string]*UserDetail, top map[string]bool, anon *Anonymiser) error {
+	merged := make(map[string]*UserDetail)
+	for login, d := range details {
+		login = topLogin(top, login)
+		m, ok := merged[login]
+		if !ok {
+			m = &UserDetail{RepoPRs: make(map[string]int)}
+			merged[login] = m
+		}
+		m.PRs += d.PRs
+		for k, count := range d.RepoPRs {
+			m.RepoPRs[k] += count
+		}
+	}
+	for _, m := range merged {
+		for _, k := range config.Repos {
+			if _, ok := m.RepoPRs[k]; ok {
+				m.Repos = append(m.Repos, k)
+			}
+		}
+	}
+
+	logins := make([]string, 0, len(merged))
+	for login := range merged {
+		logins = append(logins, login)
+	}
+	sortOthersLast(logins)
+
+	w := csv.NewWriter(out)
+	w.Write([]string{"Login", "Repos", "Repo List", "PRs"})
+	for _, login := range logins {
+		d := merged[login]
+		w.Write([]string{
+			anon.Name(login),
+			strconv.Itoa(len(d.Repos)),
+			strings.Join(d.Repos, " "),
+			strconv.Itoa(d.PRs),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func genUsersMatrix(config Config, details map[string]*UserDetail, top map[string]bool, anon *Anonymiser) error {
+	return writeFile("users-matrix.csv", "user matrix", func(out io.Writer) error {
+		return writeUsersMatrix(out, config, details, top, anon)
+	})
+}
+
+// writeUsersMatrix writes a row per user to out, with a column per repo
+// holding the PRs of the user in that repo, and the total over all
+// repos. The users outside the top contributors share a row.
+func writeUsersMatrix(out io.Writer, config Config, details map[string]*UserDetail, top map[string]bool, anon *Anonymiser) error {
+	repoPRs := make(map[string]map[string]int)
+	prs := make(map[string]int)
+	for login, d := range details {
+		login = topLogin(top, login)
+		if _, ok := repoPRs[login]; !ok {
+			repoPRs[login] = make(map[string]int)
+		}
+		for k, count := range d.RepoPRs {
+			repoPRs[login][k] += count
+		}
+		prs[login] += d.PRs
+	}
+
+	logins := make([]string, 0, len(prs))
+	for login := range prs {
+		logins = append(logins, login)
+	}
+	sortOthersLast(logins)
+
+	w := csv.NewWriter(out)
+	header := append([]string{"Login"}, config.Repos...)
+	w.Write(append(header, "Total"))
+	for _, login := range logins {
+		line := []string{anon.Name(login)}
+		for _, k := range config.Repos {
+			line = append(line, strconv.Itoa(repoPRs[login][k]))
+		}
+		w.Write(append(line, strconv.Itoa(prs[login])))
+	}
+	w.Flush()
+	return w.Error()
+}
+
+type Repo struct {
+	start   time.Time
+	created time.Time // For searches, the creation of the oldest PR
+	prs     []PrEntry
+	seed    []PrEntry // Older PRs only seeding the contributors
+	rows    []PrRow   // The SQLite rows of streamed PRs
+	pulses  []Pulse
+	fetch   FetchStats
+	summary RepoSummary
+	stream  bool // PRs are streamed into the pulses, and not retained
+}
+
+// FetchStats records the cost of reading the PRs of a repo.
+type FetchStats struct {
+	Pages    int
+	Nodes    int
+	Duration time.Duration
+	Cost     int // Github API rate limit points
+}
+
+// add adds the stats of another request to s.
+func (s *FetchStats) add(o FetchStats) {
+	s.Pages += o.Pages
+	s.Nodes += o.Nodes
+	s.Duration += o.Duration
+	s.Cost += o.Cost
+}
+
+func (s FetchStats) String() string {
+	return fmt.Sprintf("%d pages, %d nodes, %s, cost %d", s.Pages, s.Nodes, s.Duration.Round(time.Millisecond), s.Cost)
+}
+
+type PrEntry struct {
+	ID           string
+	Number       int
+	Additions    int
+	ChangedFiles int
+	ClosedAt     *time.Time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	MergedAt     *time.Time
+	Deletions    int
+	State        string
+	Title        string
+	Body         string
+	BaseRefName  string
+	Author       struct {
+		Login string
+	}
+	AuthorAssociation string
+	Repository        struct {
+		NameWithOwner string
+	}
+	Approvals struct {
+		TotalCount int
+		Nodes      []struct {
+			SubmittedAt *time.Time
+		}
+	} `graphql:"approvals: reviews(states: [APPROVED], first: 1) @include(if: $reviews)"`
+	MergeQueue struct {
+		Nodes []struct {
+			AddedToMergeQueueEvent struct {
+				CreatedAt time.Time
+			} `graphql:"... on AddedToMergeQueueEvent"`
+		}
+	} `graphql:"mergeQueue: timelineItems(itemTypes: [ADDED_TO_MERGE_QUEUE_EVENT], last: 1)"`
+	ReviewThreads struct {
+		TotalCount int
+	} `graphql:"reviewThreads @include(if: $comments)"`
+	MergedBy struct {
+		Login string
+	} `graphql:"mergedBy @include(if: $mergedBy)"`
+	Assignees struct {
+		Nodes []struct {
+			Login string
+		}
+	} `graphql:"assignees(first: 1)"`
+	// Only requested for the label weights
+	Labels struct {
+		Nodes []struct {
+			Name string
+		}
+	} `graphql:"labels(first: 20) @include(if: $labels)"`
+	ClosingIssuesReferences struct {
+		TotalCount int
+	}
+	// Only requested for the "commits" line source
+	Commits struct {
+		Nodes []struct {
+			Commit struct {
+				Additions int
+				Deletions int
+				Parents   struct {
+					TotalCount int
+				}
+			}
+		}
+	} `graphql:"commits(first: 100) @include(if: $commitLines)"`
+	// Only requested for the email domains
+	FirstCommit struct {
+		Nodes []struct {
+			Commit struct {
+				Author struct {
+					Email string
+				}
+			}
+		}
+	} `graphql:"firstCommit: commits(first: 1) @include(if: $emailDomains)"`
+	// Only requested for the reviewer turnaround
+	Timeline struct {
+		Nodes []struct {
+			ReviewRequested struct {
+				CreatedAt         time.Time
+				RequestedReviewer struct {
+					User struct {
+						Login string
+					} `graphql:"... on User"`
+				}
+			} `graphql:"... on ReviewRequestedEvent"`
+			Review struct {
+				Author struct {
+					Login string
+				}
+				SubmittedAt *time.Time
+			} `graphql:"... on PullRequestReview"`
+		}
+	} `graphql:"timeline: timelineItems(first: 100, itemTypes: [REVIEW_REQUESTED_EVENT, PULL_REQUEST_REVIEW]) @include(if: $reviewTurnaround)"`
+}
+
+// prEntryVariables returns the variables of the optional fields of
+// PrEntry, to which the variables of the query reading the PRs are
+// added.
+func prEntryVariables(config Config) map[string]interface{} {
+	return map[string]interface{}{
+		"commitLines":      commitLines(config),
+		"reviewTurnaround": githubv4.Boolean(config.Settings.PR.ReviewTurnaround),
+		"labels":           githubv4.Boolean(len(config.Settings.PR.LabelWeights) > 0 && !omittedField(config, "labels")),
+		"reviews":          includeField(config, "reviews"),
+		"comments":         includeField(config, "comments"),
+		"mergedBy":         includeField(config, "merged_by"),
+		"emailDomains":     githubv4.Boolean(config.Settings.Contributors.EmailDomains),
+	}
+}
+
+type RepoEntry struct {
+	Repository struct {
+		DefaultBranchRef struct {
+			Name string
+		}
+		CreatedAt    time.Time
+		PullRequests struct {
+			Nodes    []PrEntry
+			PageInfo struct {
+				EndCursor   githubv4.String
+				HasNextPage bool
+			}
+			TotalCount int
+		} `graphql:"pullRequests(first: 100, after: $nodesCursor, states: $states, orderBy: $orderBy)"`
+	} `graphql:"repository(owner: $owner, name: $name)"`
+	RateLimit struct {
+		Cost int
+	}
+}
+
+// repoPulls returns the creation time of a repo and its PRs against the
+// default branch, along with the older PRs read to seed the
+// contributors. If first is not nil, it is used as the first page of the
+// PR history instead of requesting it.
+func repoPulls(ctx context.Context, client *githubv4.Client, config Config, org string, repo string, first *RepoEntry, stats *FetchStats) (start time.Time, prs []PrEntry, seed []PrEntry, err error) {
+	var q RepoEntry
+	var prsUnfiltered []PrEntry
+	cutoff, cut := fetchCutoff(config)
+
+	for attempt := 0; ; attempt++ {
+		prsUnfiltered = nil
+		read := 0
+		var total int
+		q, total, err = repoPullsScan(ctx, client, config, org, repo, first, stats, func(page RepoEntry) {
+			prsUnfiltered = append(prsUnfiltered, page.Repository.PullRequests.Nodes...)
+			read += fetchedPulls(config, page.Repository.PullRequests.Nodes)
+		})
+		if err != nil {
+			return start, prs, seed, err
+		}
+
+		// A mismatch means a page was dropped or the repo changed
+		// while we were paginating through it.
+		if read == total {
+			break
+		}
+		fmt.Printf("%s/%s: warning: read %d prs but expected %d\n", org, repo, read, total)
+
+		if attempt >= config.Settings.Fetch.Rescan {
+			break
+		}
+		fmt.Printf("%s/%s: re-scanning pr history (%d/%d)...\n", org, repo, attempt+1, config.Settings.Fetch.Rescan)
+		first = nil
+	}
+
+	for _, v := range prsUnfiltered {
+		if !defaultBranchPR(config, v.BaseRefName, q.Repository.DefaultBranchRef.Name) {
+			continue
+		}
+		if cut && v.UpdatedAt.Before(cutoff) {
+			// On the last page, but updated before the cutoff
+			seed = append(seed, v)
+		} else {
+			prs = append(prs, v)
+		}
+	}
+	fmt.Printf("\r%s/%s: %d prs against branch %s...\n", org, repo, len(prs), q.Repository.DefaultBranchRef.Name)
+
+	if page := q.Repository.PullRequests.PageInfo; page.HasNextPage {
+		older, err := repoSeedPulls(ctx, client, config, org, repo, q.Repository.DefaultBranchRef.Name, page.EndCursor, stats)
+		if err != nil {
+			return start, prs, seed, err
+		}
+		seed = append(seed, older...)
+	}
+
+	return q.Repository.CreatedAt, prs, seed, nil
+}
+
+// defaultBranchPR returns true if a PR against the base branch is a PR
+// against the default branch. With PR.BranchAliases, PRs against a
+// former name of the default branch (such as master renamed to main)
+// count as well, as merged PRs keep the branch name they merged into.
+func defaultBranchPR(config Config, base string, defaultBranch string) bool {
+	if base == defaultBranch {
+		return true
+	}
+	alias, ok := config.Settings.PR.BranchAliases[base]
+	return ok && alias == defaultBranch
+}
+
+// hasBranchAlias returns true if a former name maps to the branch.
+func hasBranchAlias(config Config, branch string) bool {
+	for _, alias := range config.Settings.PR.BranchAliases {
+		if alias == branch {
+			return true
+		}
+	}
+	return false
+}
+
+// repoPullsStream reads the PR history of a repo page by page straight
+// into the accumulator, without retaining the PRs. On a mismatch in the
+// PR count the accumulator is reset and the history read again.
+func repoPullsStream(ctx context.Context, client *githubv4.Client, config Config, org string, repo string, stats *FetchStats, acc *PulseAccumulator) error {
+	var q RepoEntry
+	var err error
+	prs := 0
+	cutoff, cut := fetchCutoff(config)
+
+	for attempt := 0; ; attempt++ {
+		read := 0
+		prs = 0
+		acc.Reset()
+		var total int
+		q, total, err = repoPullsScan(ctx, client, config, org, repo, nil, stats, func(page RepoEntry) {
+			read += fetchedPulls(config, page.Repository.PullRequests.Nodes)
+			for _, v := range page.Repository.PullRequests.Nodes {
+				if !defaultBranchPR(config, v.BaseRefName, page.Repository.DefaultBranchRef.Name) {
+					continue
+				}
+				if cut && v.UpdatedAt.Before(cutoff) {
+					// On the last page, but updated before the cutoff
+					acc.AddUser(v)
+				} else {
+					acc.Add(v)
+					prs += 1
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+
+		if read == total {
+			break
+		}
+		fmt.Printf("%s/%s: warning: read %d prs but expected %d\n", org, repo, read, total)
+
+		if attempt >= config.Settings.Fetch.Rescan {
+			break
+		}
+		fmt.Printf("%s/%s: re-scanning pr history (%d/%d)...\n", org, repo, attempt+1, config.Settings.Fetch.Rescan)
+	}
+	fmt.Printf("\r%s/%s: %d prs against branch %s...\n", org, repo, prs, q.Repository.DefaultBranchRef.Name)
+
+	if page := q.Repository.PullRequests.PageInfo; page.HasNextPage {
+		seed, err := repoSeedPulls(ctx, client, config, org, repo, q.Repository.DefaultBranchRef.Name, page.EndCursor, stats)
+		if err != nil {
+			return err
+		}
+		for _, v := range seed {
+			acc.AddUser(v)
+		}
+	}
+
+	return nil
+}
+
+type RepoInfoEntry struct {
+	Repository struct {
+		CreatedAt time.Time
+		Owner     struct {
+			// Either User or Organization
+			Typename string `graphql:"__typename"`
 		}
-		CreatedAt    time.Time
+	} `graphql:"repository(owner: $owner, name: $name)"`
+}
+
+// repoInfo returns the creation time and owner type of a repo.
+func repoInfo(ctx context.Context, client *githubv4.Client, org string, repo string) (q RepoInfoEntry, err error) {
+	variables := map[string]interface{}{
+		"owner": githubv4.String(org),
+		"name":  githubv4.String(repo),
+	}
+	err = client.Query(ctx, &q, variables)
+	if err != nil {
+		return q, fmt.Errorf("repo request failed: %w", err)
+	}
+	return q, nil
+}
+
+// repoCreated returns the creation time of a repo.
+func repoCreated(ctx context.Context, client *githubv4.Client, org string, repo string) (time.Time, error) {
+	q, err := repoInfo(ctx, client, org, repo)
+	return q.Repository.CreatedAt, err
+}
+
+// ownerDesc describes the type of the owner of a repo, as reported by
+// Github. Repos owned by users are scanned the same as those owned by
+// organizations.
+func ownerDesc(typename string) string {
+	switch typename {
+	case "User":
+		return "a user account"
+	case "Organization":
+		return "an organization"
+	}
+	return "an unknown owner type"
+}
+
+// repoPullsScan paginates through the complete PR history of a repo,
+// passing each page to consume. The last page is returned so the caller
+// has access to the repo level details, along with the number of PRs
+// expected to be read. If first is not nil, it is used as the first page
+// instead of requesting it. The fetch statistics are accumulated in
+// stats.
+func repoPullsScan(ctx context.Context, client *githubv4.Client, config Config, org string, repo string, first *RepoEntry, stats *FetchStats, consume func(page RepoEntry)) (q RepoEntry, total int, err error) {
+	states, err := prStates(config)
+	if err != nil {
+		return q, total, err
+	}
+
+	variables := prEntryVariables(config)
+	variables["owner"] = githubv4.String(org)
+	variables["name"] = githubv4.String(repo)
+	variables["nodesCursor"] = (*githubv4.String)(nil)
+	variables["states"] = states
+	variables["orderBy"] = prOrder(config)
+	cutoff, cut := fetchCutoff(config)
+	var guard pageGuard
+	done := 0
+	pages := 0
+	begin := time.Now()
+	defer func() {
+		stats.Duration += time.Since(begin)
+	}()
+	if cut {
+		// The PR count of the repo includes the PRs before the
+		// cutoff, which are not read
+		total, err = updatedCount(ctx, client, config, org, repo, cutoff, stats)
+		if err != nil {
+			return q, total, err
+		}
+	}
+	for {
+		if first != nil {
+			// Already requested, and accounted for in stats
+			q = *first
+			first = nil
+		} else {
+			q = RepoEntry{}
+			err := client.Query(ctx, &q, variables)
+			if msgs := graphqlErrors(err); msgs != nil && len(q.Repository.PullRequests.Nodes) > 0 {
+				// Use the PRs returned despite the errors
+				logPartial(org+"/"+repo, msgs)
+				err = nil
+			}
+			if err != nil {
+				return q, total, fmt.Errorf("repo requests failed: %w\n", err)
+			}
+			stats.Pages += 1
+			stats.Cost += q.RateLimit.Cost
+		}
+
+		consume(q)
+
+		stats.Nodes += len(q.Repository.PullRequests.Nodes)
+
+		done += 100
+		pages += 1
+		if !cut {
+			total = q.Repository.PullRequests.TotalCount
+		}
+		if done < total && !*quiet {
+			// Estimate the remaining time from the average page fetch time
+			remaining := (total - done + 99) / 100
+			eta := time.Since(begin) / time.Duration(pages) * time.Duration(remaining)
+			fmt.Printf("\r%s/%s: reading pr history (%d/%d)... ETA %s   ", org, repo, done, total, eta.Round(time.Second))
+		}
+
+		if !q.Repository.PullRequests.PageInfo.HasNextPage {
+			break
+		}
+		if nodes := q.Repository.PullRequests.Nodes; cut && len(nodes) > 0 && nodes[len(nodes)-1].UpdatedAt.Before(cutoff) {
+			// The remaining PRs were all last updated before the cutoff
+			break
+		}
+		if guard.Stuck(len(q.Repository.PullRequests.Nodes), q.Repository.PullRequests.PageInfo.EndCursor) {
+			fmt.Printf("\n%s/%s: warning: pr history pagination is not advancing, stopping early\n", org, repo)
+			break
+		}
+		variables["nodesCursor"] = githubv4.NewString(q.Repository.PullRequests.PageInfo.EndCursor)
+	}
+
+	if !*quiet {
+		fmt.Printf("\r%s/%s: reading pr history (%d/%d)...              \n", org, repo, total, total)
+	}
+
+	return q, total, nil
+}
+
+// fetchedPulls returns the number of PRs of a page within the fetched
+// range. With a cutoff, the last page read also holds PRs updated before
+// the cutoff, which are not part of the expected count.
+func fetchedPulls(config Config, nodes []PrEntry) int {
+	cutoff, cut := fetchCutoff(config)
+	if !cut {
+		return len(nodes)
+	}
+	n := 0
+	for _, p := range nodes {
+		if !p.UpdatedAt.Before(cutoff) {
+			n += 1
+		}
+	}
+	return n
+}
+
+// updatedCount returns the number of PRs of a repo in the included
+// states that were updated since the cutoff, from the Github search.
+func updatedCount(ctx context.Context, client *githubv4.Client, config Config, org string, repo string, cutoff time.Time, stats *FetchStats) (int, error) {
+	qualifiers, err := stateQualifiers(config)
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, qualifier := range qualifiers {
+		var q struct {
+			Search struct {
+				IssueCount int
+			} `graphql:"search(query: $query, type: ISSUE)"`
+			RateLimit struct {
+				Cost int
+			}
+		}
+		query := fmt.Sprintf("repo:%s/%s is:pr updated:>=%s%s", org, repo, cutoff.UTC().Format(time.RFC3339), qualifier)
+		err := client.Query(ctx, &q, map[string]interface{}{"query": githubv4.String(query)})
+		if err != nil {
+			return 0, fmt.Errorf("pr count request failed: %w", err)
+		}
+		stats.Cost += q.RateLimit.Cost
+		total += q.Search.IssueCount
+	}
+	return total, nil
+}
+
+// stateQualifiers returns the search qualifiers of the included PR
+// states, each to be counted with a separate search. There is no
+// qualifier for closed but not merged PRs, so a single empty qualifier
+// is returned only if all states are included.
+func stateQualifiers(config Config) ([]string, error) {
+	states, err := prStates(config)
+	if err != nil {
+		return nil, err
+	}
+	if len(states) >= 3 {
+		return []string{""}, nil
+	}
+	var qualifiers []string
+	for _, state := range states {
+		switch state {
+		case githubv4.PullRequestStateOpen:
+			qualifiers = append(qualifiers, " is:open")
+		case githubv4.PullRequestStateClosed:
+			qualifiers = append(qualifiers, " is:closed is:unmerged")
+		case githubv4.PullRequestStateMerged:
+			qualifiers = append(qualifiers, " is:merged")
+		}
+	}
+	return qualifiers, nil
+}
+
+// prOrder returns the order of the PR history. The history is read most
+// recently updated first if only the recently updated PRs are needed,
+// and oldest first otherwise.
+func prOrder(config Config) githubv4.IssueOrder {
+	if _, cut := fetchCutoff(config); cut {
+		return githubv4.IssueOrder{Field: githubv4.IssueOrderFieldUpdatedAt, Direction: githubv4.OrderDirectionDesc}
+	}
+	return githubv4.IssueOrder{Field: githubv4.IssueOrderFieldCreatedAt, Direction: githubv4.OrderDirectionAsc}
+}
+
+// fetchCutoff returns the update time before which PRs are not read,
+// which is the graph start less Fetch.LookbackDays. The lookback covers
+// PRs that were open at the graph start without being updated since.
+// The cutoff only applies with Fetch.UpdatedOnly.
+func fetchCutoff(config Config) (time.Time, bool) {
+	return startCutoff(config, config.Settings.Fetch.LookbackDays)
+}
+
+// seedCutoff returns the update time until which the PRs before the
+// fetch cutoff are read to seed the contributors, which is the graph
+// start less Fetch.SeedDays. It only applies with Fetch.UpdatedOnly,
+// and if Fetch.SeedDays is longer than Fetch.LookbackDays.
+func seedCutoff(config Config) (time.Time, bool) {
+	if config.Settings.Fetch.SeedDays <= config.Settings.Fetch.LookbackDays {
+		return time.Time{}, false
+	}
+	return startCutoff(config, config.Settings.Fetch.SeedDays)
+}
+
+// startCutoff returns the graph start less the given number of days,
+// if only the PRs updated since are read.
+func startCutoff(config Config, days int) (time.Time, bool) {
+	if !config.Settings.Fetch.UpdatedOnly || config.Settings.Graphs.Start == nil {
+		return time.Time{}, false
+	}
+	start, err := parseStart(*config.Settings.Graphs.Start, now)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return start.AddDate(0, 0, -days), true
+}
+
+// SeedEntry is a page of the PR history of a repo with only the fields
+// needed for the activity of the contributors.
+type SeedEntry struct {
+	Repository struct {
 		PullRequests struct {
-			Nodes    []PrEntry
+			Nodes []struct {
+				Number            int
+				CreatedAt         time.Time
+				UpdatedAt         time.Time
+				MergedAt          *time.Time
+				ClosedAt          *time.Time
+				State             string
+				BaseRefName       string
+				AuthorAssociation string
+				Author            struct {
+					Login string
+				}
+				MergedBy struct {
+					Login string
+				} `graphql:"mergedBy @include(if: $mergedBy)"`
+				Assignees struct {
+					Nodes []struct {
+						Login string
+					}
+				} `graphql:"assignees(first: 1)"`
+			}
 			PageInfo struct {
 				EndCursor   githubv4.String
 				HasNextPage bool
 			}
-			TotalCount int
-		} `graphql:"pullRequests(first: 100, after: $nodesCursor)"`
+		} `graphql:"pullRequests(first: 100, after: $nodesCursor, states: $states, orderBy: $orderBy)"`
 	} `graphql:"repository(owner: $owner, name: $name)"`
+	RateLimit struct {
+		Cost int
+	}
 }
 
-func repoPulls(ctx context.Context, client *githubv4.Client, org string, repo string) (start time.Time, prs []PrEntry, err error) {
-	var q RepoEntry
+// repoSeedPulls continues reading the PR history of a repo after the
+// fetch cutoff, from the cursor where the main scan stopped, until the
+// seed cutoff. The PRs returned only seed the contributors, and are not
+// counted in the pulses, so only the fields needed for that are read.
+func repoSeedPulls(ctx context.Context, client *githubv4.Client, config Config, org string, repo string, branch string, cursor githubv4.String, stats *FetchStats) (prs []PrEntry, err error) {
+	cutoff, ok := seedCutoff(config)
+	if !ok {
+		return nil, nil
+	}
+	states, err := prStates(config)
+	if err != nil {
+		return nil, err
+	}
 
 	variables := map[string]interface{}{
 		"owner":       githubv4.String(org),
 		"name":        githubv4.String(repo),
-		"nodesCursor": (*githubv4.String)(nil),
+		"nodesCursor": githubv4.NewString(cursor),
+		"states":      states,
+		"orderBy":     prOrder(config),
+		"mergedBy":    includeField(config, "merged_by"),
 	}
-	done := 0
-	total := 0
-	var prsUnfiltered []PrEntry
+	var guard pageGuard
+	begin := time.Now()
+	defer func() {
+		stats.Duration += time.Since(begin)
+	}()
+	for {
+		var q SeedEntry
+		err := client.Query(ctx, &q, variables)
+		if err != nil {
+			return prs, fmt.Errorf("contributor history requests failed: %w", err)
+		}
+		stats.Pages += 1
+		stats.Nodes += len(q.Repository.PullRequests.Nodes)
+		stats.Cost += q.RateLimit.Cost
+
+		nodes := q.Repository.PullRequests.Nodes
+		for _, v := range nodes {
+			if !defaultBranchPR(config, v.BaseRefName, branch) {
+				continue
+			}
+			var e PrEntry
+			e.Number = v.Number
+			e.CreatedAt = v.CreatedAt
+			e.UpdatedAt = v.UpdatedAt
+			e.MergedAt = v.MergedAt
+			e.ClosedAt = v.ClosedAt
+			e.State = v.State
+			e.BaseRefName = v.BaseRefName
+			e.AuthorAssociation = v.AuthorAssociation
+			e.Author.Login = v.Author.Login
+			e.MergedBy.Login = v.MergedBy.Login
+			e.Assignees.Nodes = v.Assignees.Nodes
+			e.Repository.NameWithOwner = org + "/" + repo
+			prs = append(prs, e)
+		}
+
+		if !*quiet {
+			fmt.Printf("\r%s/%s: reading contributor history (%d)...", org, repo, len(prs))
+		}
+		if !q.Repository.PullRequests.PageInfo.HasNextPage {
+			break
+		}
+		if len(nodes) > 0 && nodes[len(nodes)-1].UpdatedAt.Before(cutoff) {
+			// The remaining PRs were all last updated before the cutoff
+			break
+		}
+		if guard.Stuck(len(nodes), q.Repository.PullRequests.PageInfo.EndCursor) {
+			fmt.Printf("\n%s/%s: warning: contributor history pagination is not advancing, stopping early\n", org, repo)
+			break
+		}
+		variables["nodesCursor"] = githubv4.NewString(q.Repository.PullRequests.PageInfo.EndCursor)
+	}
+	fmt.Printf("\r%s/%s: %d prs seeding the contributors...\n", org, repo, len(prs))
+
+	return prs, nil
+}
+
+// pageGuard detects pagination that stopped advancing. Github has been
+// seen to return empty pages with an unchanged cursor while reporting
+// more pages, which would otherwise paginate forever.
+type pageGuard struct {
+	cursor githubv4.String
+	stuck  int
+}
+
+// Stuck records a page with the given number of nodes and end cursor,
+// and reports whether more than one page in a row made no progress.
+func (g *pageGuard) Stuck(nodes int, cursor githubv4.String) bool {
+	if nodes == 0 || cursor == g.cursor {
+		g.stuck += 1
+	} else {
+		g.stuck = 0
+	}
+	g.cursor = cursor
+	return g.stuck > 1
+}
+
+type SearchEntry struct {
+	Search struct {
+		IssueCount int
+		Nodes      []struct {
+			PullRequest PrEntry `graphql:"... on PullRequest"`
+		}
+		PageInfo struct {
+			EndCursor   githubv4.String
+			HasNextPage bool
+		}
+	} `graphql:"search(query: $query, type: ISSUE, first: 100, after: $nodesCursor)"`
+	RateLimit struct {
+		Cost int
+	}
+}
+
+func searchKey(s Search) string {
+	return "search/" + s.Name
+}
+
+// searchPulls returns all the PRs matching a search query. As there is no
+// repo creation time, the returned start time is the creation time of
+// the oldest PR found. Note that Github limits searches to 1000 results.
+func searchPulls(ctx context.Context, client *githubv4.Client, config Config, s Search, stats *FetchStats) (start time.Time, prs []PrEntry, err error) {
+	var q SearchEntry
+
+	begin := time.Now()
+	defer func() {
+		stats.Duration += time.Since(begin)
+	}()
+
+	variables := prEntryVariables(config)
+	variables["query"] = githubv4.String(s.Query)
+	variables["nodesCursor"] = (*githubv4.String)(nil)
+	var guard pageGuard
+	start = now
 	for {
+		q = SearchEntry{}
 		err := client.Query(ctx, &q, variables)
+		if msgs := graphqlErrors(err); msgs != nil && len(q.Search.Nodes) > 0 {
+			// Use the PRs returned despite the errors
+			logPartial(searchKey(s), msgs)
+			err = nil
+		}
 		if err != nil {
-			return start, prs, fmt.Errorf("repo requests failed: %w\n", err)
+			return start, prs, fmt.Errorf("search requests failed: %w", err)
+		}
+
+		stats.Pages += 1
+		stats.Nodes += len(q.Search.Nodes)
+		stats.Cost += q.RateLimit.Cost
+
+		for _, v := range q.Search.Nodes {
+			// Issues matching the query have no PR fields
+			if v.PullRequest.CreatedAt.IsZero() {
+				continue
+			}
+			prs = append(prs, v.PullRequest)
+			if start.After(v.PullRequest.CreatedAt) {
+				start = v.PullRequest.CreatedAt
+			}
+		}
+
+		if !*quiet {
+			fmt.Printf("\r%s: reading search results (%d/%d)...", searchKey(s), len(prs), q.Search.IssueCount)
+		}
+
+		if !q.Search.PageInfo.HasNextPage {
+			break
+		}
+		if guard.Stuck(len(q.Search.Nodes), q.Search.PageInfo.EndCursor) {
+			fmt.Printf("\n%s: warning: search pagination is not advancing, stopping at %d prs\n", searchKey(s), len(prs))
+			break
+		}
+		variables["nodesCursor"] = githubv4.NewString(q.Search.PageInfo.EndCursor)
+	}
+
+	fmt.Printf("\r%s: %d prs matching %q...\n", searchKey(s), len(prs), s.Query)
+
+	return start, prs, nil
+}
+
+// dedupePulls removes PRs that were read from more than one of the
+// configured repos, which happens when a repo was moved or renamed and
+// both names are configured. PRs are identified by their node ID, and
+// are kept in the repo they currently belong to. If none of the repos
+// is the current repo, the PR is kept in the first configured repo.
+func dedupePulls(config Config, repos map[string]*Repo) {
+	owner := make(map[string]string)
+	for _, k := range config.Repos {
+		for _, p := range repos[k].prs {
+			if current, ok := owner[p.ID]; ok {
+				if strings.EqualFold(current, p.Repository.NameWithOwner) {
+					continue
+				}
+				if !strings.EqualFold(k, p.Repository.NameWithOwner) {
+					continue
+				}
+			}
+			owner[p.ID] = k
+		}
+	}
+
+	for _, k := range config.Repos {
+		prs := make([]PrEntry, 0, len(repos[k].prs))
+		for _, p := range repos[k].prs {
+			if p.ID == "" || owner[p.ID] == k {
+				prs = append(prs, p)
+			}
+		}
+		if removed := len(repos[k].prs) - len(prs); removed > 0 {
+			fmt.Printf("%s: removed %d prs also found in other repos\n", k, removed)
+		}
+		repos[k].prs = prs
+	}
+}
+
+// commitLines returns whether the commits of PRs need to be requested
+// for the line counts, as the query variable of PrEntry.
+func commitLines(config Config) githubv4.Boolean {
+	return githubv4.Boolean(config.Settings.PR.LineSource == "commits")
+}
+
+// prStates returns the PR states to include, as configured by PR.States.
+// If no states are configured, all states are included.
+func prStates(config Config) ([]githubv4.PullRequestState, error) {
+	if len(config.Settings.PR.States) == 0 {
+		return []githubv4.PullRequestState{
+			githubv4.PullRequestStateOpen,
+			githubv4.PullRequestStateClosed,
+			githubv4.PullRequestStateMerged,
+		}, nil
+	}
+
+	states := make([]githubv4.PullRequestState, 0)
+	for _, v := range config.Settings.PR.States {
+		state := githubv4.PullRequestState(strings.ToUpper(v))
+		switch state {
+		case githubv4.PullRequestStateOpen, githubv4.PullRequestStateClosed, githubv4.PullRequestStateMerged:
+			states = append(states, state)
+		default:
+			return nil, fmt.Errorf("unknown pr state %q", v)
+		}
+	}
+	return states, nil
+}
+
+// includedState returns true if PRs in the supplied state are included,
+// as configured by PR.States.
+func includedState(config Config, state string) bool {
+	if len(config.Settings.PR.States) == 0 {
+		// Empty list means all states are included
+		return true
+	}
+
+	for _, v := range config.Settings.PR.States {
+		if strings.EqualFold(v, state) {
+			return true
+		}
+	}
+	return false
+}
+
+func orgRepoSplit(key string) (org string, repo string, err error) {
+	elements := strings.Split(key, "/")
+	if len(elements) == 2 {
+		return elements[0], elements[1], nil
+	}
+	return "", "", fmt.Errorf("repo JSON key invalid")
+}
+
+type User struct {
+	Start time.Time
+	End   time.Time // Last actual activity
+	// End promoted to the current time if the last activity is within
+	// the cooldown period
+	ActiveUntil time.Time
+	PRs         int  // Number of PRs created
+	Internal    bool // Member or collaborator of the repo owner
+}
+
+// getUsers returns the contributors of the PRs and of the seed PRs,
+// which are read only for the activity of the contributors.
+func getUsers(config Config, pulls []PrEntry, seed []PrEntry) map[string]User {
+	users := make(map[string]User)
+	for _, r := range pulls {
+		addUser(config, users, r)
+	}
+	for _, r := range seed {
+		addUser(config, users, r)
+	}
+	dropDriveBy(config, users)
+	return users
+}
+
+// dropDriveBy removes the users with fewer PRs than
+// Contributors.MinPRs, so drive-by contributors do not count as
+// contributors.
+func dropDriveBy(config Config, users map[string]User) {
+	for login, u := range users {
+		if u.PRs < config.Settings.Contributors.MinPRs {
+			delete(users, login)
+		}
+	}
+}
+
+// addUser updates the activity period of the author of a PR.
+func addUser(config Config, users map[string]User, r PrEntry) {
+	login := contributorLogin(config, r)
+	if login == "" {
+		return
+	}
+
+	if strings.HasPrefix(login, "renovate") {
+		return
+	}
+
+	if excludedMember(config, login) {
+		return
+	}
+
+	if includedState(config, r.State) == false {
+		return
+	}
+
+	var endTime time.Time
+	if r.MergedAt != nil {
+		endTime = *r.MergedAt
+	} else if r.ClosedAt != nil {
+		endTime = *r.ClosedAt
+	} else {
+		endTime = now
+	}
+	startTime := r.CreatedAt
+
+	// Update existing
+	if val, ok := users[login]; ok {
+		if val.End.After(endTime) {
+			endTime = val.End
+		}
+		if val.Start.Before(startTime) {
+			startTime = val.Start
+		}
+	}
+
+	prs := 1
+	internal := internalContributor(config, r)
+	if val, ok := users[login]; ok {
+		prs += val.PRs
+		internal = internal || val.Internal
+	}
+
+	// Promote to current time if user contributed
+	// in the last x months
+	activeUntil := endTime
+	cooldown := config.Settings.Contributors.Cooldown * 30 * 24
+	if now.Sub(endTime) < (time.Duration(cooldown) * time.Hour) {
+		activeUntil = now
+	}
+
+	users[login] = User{
+		Start:       startTime,
+		End:         endTime,
+		ActiveUntil: activeUntil,
+		PRs:         prs,
+		Internal:    internal,
+	}
+}
+
+// internalContributor returns true if the author association of a PR
+// marks the contributor as internal: an owner, member or collaborator.
+// The association is that of the PR author, so with other contributor
+// fields all contributors are external.
+func internalContributor(config Config, p PrEntry) bool {
+	if f := config.Settings.Contributors.Field; f != "" && f != "author" {
+		return false
+	}
+	switch p.AuthorAssociation {
+	case "OWNER", "MEMBER", "COLLABORATOR":
+		return true
+	}
+	return false
+}
+
+// internalCount returns the number of internal contributors of the
+// active contributors.
+func internalCount(active map[string]bool, users map[string]User) int {
+	count := 0
+	for login := range active {
+		if users[login].Internal {
+			count += 1
+		}
+	}
+	return count
+}
+
+// repoConfig returns the config used for generating the metrics of a
+// repo. If the repo has its own allowlist, it replaces the global one.
+func repoConfig(config Config, key string) Config {
+	if allowlist, ok := config.Settings.Contributors.RepoAllowlists[key]; ok {
+		config.Settings.Contributors.Allowlist = allowlist
+	}
+	return config
+}
+
+func allowlistedUser(config Config, login string) bool {
+	if len(config.Settings.Contributors.Allowlist) == 0 {
+		// Empty list means all users are tracked
+		return true
+	}
+
+	for _, u := range config.Settings.Contributors.Allowlist {
+		if u == login {
+			return true
 		}
+	}
+	return false
+}
 
-		prsUnfiltered = append(prsUnfiltered, q.Repository.PullRequests.Nodes...)
+// allowlistedDuring returns true if a contributor is allowlisted for the
+// window [start, end). An allowlisted contributor with an allowlist
+// period is only allowlisted for the windows overlapping the period, so
+// historical pulses are not affected by later allowlist changes.
+func allowlistedDuring(config Config, login string, start time.Time, end time.Time) bool {
+	if !allowlistedUser(config, login) {
+		return false
+	}
+	if period, ok := config.Settings.Contributors.Periods[login]; ok {
+		return period.Overlaps(start, end)
+	}
+	return true
+}
 
-		done += 100
-		total = q.Repository.PullRequests.TotalCount
-		if done < total {
-			fmt.Printf("\r%s/%s: reading pr history (%d/%d)...", org, repo, done, total)
+// activeContributors returns the logins of the allowlisted contributors
+// active between start and end.
+func activeContributors(config Config, users map[string]User, start time.Time, end time.Time) map[string]bool {
+	active := make(map[string]bool)
+	for k, v := range users {
+		if allowlistedDuring(config, k, start, end) == false {
+			// Ignore this user
+			continue
 		}
 
-		if !q.Repository.PullRequests.PageInfo.HasNextPage {
-			break
+		// Historical reports should not consider the cooldown
+		userEnd := v.ActiveUntil
+		if config.Settings.Contributors.Basis == "raw" {
+			userEnd = v.End
 		}
-		variables["nodesCursor"] = githubv4.NewString(q.Repository.PullRequests.PageInfo.EndCursor)
-	}
-
-	fmt.Printf("\r%s/%s: reading pr history (%d/%d)...\n", org, repo, total, total)
 
-	for _, v := range prsUnfiltered {
-		if v.BaseRefName == q.Repository.DefaultBranchRef.Name {
-			prs = append(prs, v)
+		if v.Start.Before(end) == true && userEnd.Before(start) == false {
+			active[k] = true
 		}
 	}
-	fmt.Printf("\r%s/%s: %d prs against branch %s...\n", org, repo, len(prs), q.Repository.DefaultBranchRef.Name)
+	return active
+}
 
-	return q.Repository.CreatedAt, prs, nil
+type Pull struct {
+	Repo        string // Name with owner of the repo of the PR
+	Number      int
+	Author      string
+	Contributor string // Login identifying the contributor
+	Merged      bool
+	Closed      bool
+	Open        bool
+	Abandoned   bool // Open, but older than the maximum open age
+	Additions   int
+	Deletions   int
+	Files       int
+	Approvals   int
+	Revert      bool
+	Updated     time.Time
+	Threads     int    // Line-level review threads
+	MergedBy    string // Login of the merger, if merged and known
+	Issues      int    // Issues closed when merged
+	BodyLen     int    // Characters in the description
+	// Multiplier of the size weight from the labels
+	LabelWeight float32
+	// Email domain of the author of the first commit
+	Domain string
+	// Time from creation to the first approval, if approved
+	ApprovalLatency *time.Duration
+	// Time from entering the merge queue until merged, if queued
+	QueueLatency *time.Duration
+	// Time from creation until merged, if merged
+	MergeLatency *time.Duration
 }
 
-func orgRepoSplit(key string) (org string, repo string, err error) {
-	elements := strings.Split(key, "/")
-	if len(elements) == 2 {
-		return elements[0], elements[1], nil
+// newPull converts a PR into a Pull for the window ending at end,
+// classified by the current state of the PR. Open PRs created more than
+// PR.MaxOpenAge days before the end are classified as abandoned instead
+// of open.
+func newPull(config Config, p PrEntry, end time.Time) Pull {
+	open := (p.State == "OPEN")
+	abandoned := open && abandonedAt(config, p.CreatedAt, end)
+	open = open && !abandoned
+	merged := (p.MergedAt != nil)
+	additions, deletions := prLines(config, p)
+	return Pull{
+		Repo:        p.Repository.NameWithOwner,
+		Number:      p.Number,
+		Author:      p.Author.Login,
+		Contributor: contributorLogin(config, p),
+		Merged:      merged,
+		Closed:      !open && !merged && !abandoned,
+		Open:        open,
+		Abandoned:   abandoned,
+		Additions:   additions,
+		Deletions:   deletions,
+		Files:       p.ChangedFiles,
+		Approvals:   p.Approvals.TotalCount,
+		Revert:      isRevert(p),
+		Updated:     p.UpdatedAt,
+		Threads:     p.ReviewThreads.TotalCount,
+		MergedBy:    p.MergedBy.Login,
+		Issues:      p.ClosingIssuesReferences.TotalCount,
+		BodyLen:     utf8.RuneCountInString(strings.TrimSpace(p.Body)),
+		LabelWeight: labelWeight(config, p),
+		Domain:      emailDomain(p),
+
+		ApprovalLatency: approvalLatency(config, p),
+		QueueLatency:    queueLatency(config, p),
+		MergeLatency:    mergeLatency(config, p),
 	}
-	return "", "", fmt.Errorf("repo JSON key invalid")
 }
 
-type User struct {
-	Start time.Time
-	End   time.Time
+// contributorLogin returns the login of the person a PR is attributed
+// to, as configured by Contributors.Field. The login is empty if there
+// is no such person, such as for unmerged PRs with the "merged_by"
+// field.
+func contributorLogin(config Config, p PrEntry) string {
+	switch config.Settings.Contributors.Field {
+	case "merged_by":
+		return p.MergedBy.Login
+	case "assignee":
+		if len(p.Assignees.Nodes) == 0 {
+			return ""
+		}
+		return p.Assignees.Nodes[0].Login
+	default:
+		return p.Author.Login
+	}
 }
 
-func getUsers(config Config, pulls []PrEntry) map[string]User {
-	users := make(map[string]User)
-	for _, r := range pulls {
-		if r.Author.Login == "" {
+// prLines returns the lines added and deleted by a PR, as configured by
+// PR.LineSource. With "commits" the lines are recomputed from the
+// commits of the PR, excluding merge commits.
+func prLines(config Config, p PrEntry) (additions int, deletions int) {
+	if config.Settings.PR.LineSource != "commits" {
+		return p.Additions, p.Deletions
+	}
+	for _, c := range p.Commits.Nodes {
+		if c.Commit.Parents.TotalCount > 1 {
 			continue
 		}
-		login := r.Author.Login
+		additions += c.Commit.Additions
+		deletions += c.Commit.Deletions
+	}
+	return additions, deletions
+}
 
-		if strings.HasPrefix(login, "renovate") {
-			continue
-		}
+// pullSize returns the size of a PR used for the size weight, which is
+// the sum of its additions and deletions, each scaled by the configured
+// multiplier (1 by default).
+func pullSize(config Config, p Pull) float32 {
+	additions := float32(1.0)
+	if w := config.Settings.PR.AdditionsWeight; w != nil {
+		additions = float32(*w)
+	}
+	deletions := float32(1.0)
+	if w := config.Settings.PR.DeletionsWeight; w != nil {
+		deletions = float32(*w)
+	}
+	return additions*float32(p.Additions) + deletions*float32(p.Deletions)
+}
 
-		var endTime time.Time
-		if r.MergedAt != nil {
-			endTime = *r.MergedAt
-		} else if r.ClosedAt != nil {
-			endTime = *r.ClosedAt
-		} else {
-			endTime = time.Now().UTC()
-		}
-		startTime := r.CreatedAt
+// approvalLatency returns the time from PR creation until the first
+// approving review, or nil if the PR was never approved or its times are
+// inverted.
+func approvalLatency(config Config, p PrEntry) *time.Duration {
+	if len(p.Approvals.Nodes) == 0 || p.Approvals.Nodes[0].SubmittedAt == nil || invertedTimes(p) {
+		return nil
+	}
+	d := latency(config, p.CreatedAt, *p.Approvals.Nodes[0].SubmittedAt)
+	return &d
+}
 
-		// Update existing
-		if val, ok := users[login]; ok {
-			if val.End.After(endTime) {
-				endTime = val.End
-			}
-			if val.Start.Before(startTime) {
-				startTime = val.Start
-			}
-		}
+// queueLatency returns the time a merged PR spent in the merge queue,
+// or nil if the PR was not merged through the merge queue or its times
+// are inverted.
+func queueLatency(config Config, p PrEntry) *time.Duration {
+	if p.MergedAt == nil || len(p.MergeQueue.Nodes) == 0 || invertedTimes(p) {
+		return nil
+	}
+	d := latency(config, p.MergeQueue.Nodes[0].AddedToMergeQueueEvent.CreatedAt, *p.MergedAt)
+	return &d
+}
 
-		// Promote to current time if user contributed
-		// in the last x months
-		cooldown := config.Settings.Contributors.Cooldown * 30 * 24
-		if time.Now().Sub(endTime) < (time.Duration(cooldown) * time.Hour) {
-			endTime = time.Now().UTC()
-		}
+// mergeLatency returns the time from PR creation until merged, or nil
+// if the PR was not merged or its times are inverted.
+func mergeLatency(config Config, p PrEntry) *time.Duration {
+	if p.MergedAt == nil || invertedTimes(p) {
+		return nil
+	}
+	d := latency(config, p.CreatedAt, *p.MergedAt)
+	return &d
+}
 
-		users[login] = User{
-			Start: startTime,
-			End:   endTime,
-		}
+// invertedTimes returns true if a PR was merged before it was created,
+// which Github has been seen to report for transferred PRs. The
+// latencies of such PRs would be negative.
+func invertedTimes(p PrEntry) bool {
+	return p.MergedAt != nil && p.MergedAt.Before(p.CreatedAt)
+}
+
+// warnInverted warns about a PR with inverted times, naming the
+// latencies it is left out of.
+func warnInverted(p PrEntry) {
+	if !invertedTimes(p) {
+		return
 	}
-	return users
+	var skipped []string
+	if len(p.Approvals.Nodes) > 0 && p.Approvals.Nodes[0].SubmittedAt != nil {
+		skipped = append(skipped, "approval")
+	}
+	if len(p.MergeQueue.Nodes) > 0 {
+		skipped = append(skipped, "queue")
+	}
+	skipped = append(skipped, "merge")
+	noun := "latency"
+	if len(skipped) > 1 {
+		noun = "latencies"
+	}
+	fmt.Printf("%s: warning: pr #%d merged before it was created, ignoring its %s %s\n", p.Repository.NameWithOwner, p.Number, strings.Join(skipped, " and "), noun)
 }
 
-func allowlistedUser(config Config, login string) bool {
-	if len(config.Settings.Contributors.Allowlist) == 0 {
-		// Empty list means all users are tracked
-		return true
+// latency returns the time from start to end. With PR.BusinessHours,
+// weekends and holidays in the report timezone are not counted.
+func latency(config Config, start time.Time, end time.Time) time.Duration {
+	if !config.Settings.PR.BusinessHours {
+		return end.Sub(start)
+	}
+	loc := reportLocation(config)
+	holidays := make([]time.Time, 0, len(config.Settings.PR.Holidays))
+	for _, h := range config.Settings.PR.Holidays {
+		// Validated with the config
+		t, _ := time.ParseInLocation("2006-01-02", h, loc)
+		holidays = append(holidays, t)
 	}
+	return businessDuration(start.In(loc), end.In(loc), holidays)
+}
 
-	for _, u := range config.Settings.Contributors.Allowlist {
-		if u == login {
-			return true
+// businessDuration returns the time from start to end, excluding
+// Saturdays, Sundays and the days of the holidays. Days are calendar
+// days in the location of start.
+func businessDuration(start time.Time, end time.Time, holidays []time.Time) time.Duration {
+	excluded := make(map[string]bool, len(holidays))
+	for _, h := range holidays {
+		excluded[h.Format("2006-01-02")] = true
+	}
+
+	d := time.Duration(0)
+	for t := start; t.Before(end); {
+		y, m, day := t.Date()
+		next := time.Date(y, m, day+1, 0, 0, 0, 0, t.Location())
+		if next.After(end) {
+			next = end
 		}
+		weekend := t.Weekday() == time.Saturday || t.Weekday() == time.Sunday
+		if !weekend && !excluded[t.Format("2006-01-02")] {
+			d += next.Sub(t)
+		}
+		t = next
 	}
-	return false
+	return d
 }
 
-func pulseContributors(config Config, users map[string]User, start time.Time, end time.Time) (contributors int) {
-	for k, v := range users {
-		if allowlistedUser(config, k) == false {
-			// Ignore this user
-			continue
-		}
+// isRevert returns true if the PR reverts an earlier change, based on
+// the title prefix Github uses for revert PRs.
+func isRevert(p PrEntry) bool {
+	return strings.HasPrefix(p.Title, "Revert \"")
+}
 
-		if v.Start.Before(end) == true && v.End.Before(start) == false {
-			contributors = contributors + 1
-		}
+// windowBasis returns the basis deciding which PRs belong to a pulse.
+// Graphs.MergeOnce credits each merged PR exactly once, in the pulse it
+// merged, which is the merged basis.
+func windowBasis(config Config) string {
+	if config.Settings.Graphs.MergeOnce {
+		return "merged"
 	}
-	return contributors
+	return config.Settings.Graphs.WindowBasis
 }
 
-type Pull struct {
-	Merged bool
-	Closed bool
-	Open   bool
-	Lines  int
+// overlapsWindow returns true if the PR was open at some point during
+// [start, end). A PR open for the whole range, or created before and
+// closed during the range overlaps. A PR created at or after end, or
+// closed before start (no matter how long ago it was created) does not.
+func overlapsWindow(p PrEntry, start time.Time, end time.Time) bool {
+	return (p.ClosedAt == nil || p.ClosedAt.Before(start) == false) && p.CreatedAt.Before(end) == true
 }
 
-func pulsePulls(config Config, pulls []PrEntry, start time.Time, end time.Time) []Pull {
-	pull := make([]Pull, 0)
-	for _, p := range pulls {
-		// Only pulls by allowlisted users are tracked
-		if allowlistedUser(config, p.Author.Login) == false {
-			continue
-		}
+// inWindow returns true if t falls within [start, end).
+func inWindow(t time.Time, start time.Time, end time.Time) bool {
+	return t.Before(start) == false && t.Before(end) == true
+}
+
+// pulsePull classifies a PR for the window [start, end), returning false
+// if the PR does not belong to the window.
+func pulsePull(config Config, p PrEntry, start time.Time, end time.Time) (Pull, bool) {
+	// Only pulls by allowlisted users are tracked
+	if allowlistedDuring(config, contributorLogin(config, p), start, end) == false {
+		return Pull{}, false
+	}
+
+	// Only pulls in the configured states are tracked
+	if includedState(config, p.State) == false {
+		return Pull{}, false
+	}
 
+	switch windowBasis(config) {
+	case "created":
+		// All PRs created within the window
+		if inWindow(p.CreatedAt, start, end) {
+			return newPull(config, p, end), true
+		}
+	case "merged":
+		// All PRs merged within the window
+		if p.MergedAt != nil && inWindow(*p.MergedAt, start, end) {
+			return newPull(config, p, end), true
+		}
+	default:
 		// All PRs that overlap with the window
-		if (p.ClosedAt == nil || p.ClosedAt.Before(start) == false) && p.CreatedAt.Before(end) == true {
+		if overlapsWindow(p, start, end) {
 			// All PRs that closed within the window
 			if p.State != "OPEN" {
-				if p.ClosedAt.Before(start) == false && p.ClosedAt.Before(end) == true {
-					merged := (p.MergedAt != nil)
-					lines := p.Additions + p.Deletions
-					pull = append(pull, Pull{
-						Merged: merged,
-						Closed: !merged,
-						Open:   false,
-						Lines:  lines,
-					})
+				if inWindow(*p.ClosedAt, start, end) {
+					return newPull(config, p, end), true
 				}
 			} else {
 				// Open PRs inside the window
-				lines := p.Additions + p.Deletions
-				pull = append(pull, Pull{
-					Merged: false,
-					Closed: false,
-					Open:   true,
-					Lines:  lines,
-				})
+				return newPull(config, p, end), true
 			}
 		}
 	}
-	return pull
+	return Pull{}, false
+}
+
+// pulsesBefore returns the number of leading pulses which end before t.
+func pulsesBefore(pulses []Pulse, t time.Time) int {
+	return sort.Search(len(pulses), func(i int) bool {
+		return pulses[i].End.After(t)
+	})
+}
+
+// windowIndex returns the index of the window containing t, or -1 if t
+// is outside all windows. Windows are contiguous and in order.
+func windowIndex(windows []Pulse, t time.Time) int {
+	i := sort.Search(len(windows), func(i int) bool {
+		return windows[i].End.After(t)
+	})
+	if i == len(windows) || t.Before(windows[i].Start) {
+		return -1
+	}
+	return i
+}
+
+// candidateWindows returns the range [lo, hi) of windows a PR can belong
+// to, so pulsePull only has to be evaluated for those windows. Only open
+// PRs can belong to more than one window.
+func candidateWindows(config Config, p PrEntry, windows []Pulse) (lo int, hi int) {
+	single := func(t *time.Time) (int, int) {
+		if t == nil {
+			return 0, 0
+		}
+		i := windowIndex(windows, *t)
+		if i < 0 {
+			return 0, 0
+		}
+		return i, i + 1
+	}
+
+	switch windowBasis(config) {
+	case "created":
+		return single(&p.CreatedAt)
+	case "merged":
+		return single(p.MergedAt)
+	default:
+		if p.State != "OPEN" {
+			return single(p.ClosedAt)
+		}
+		// Every window ending after the PR was created
+		lo = sort.Search(len(windows), func(i int) bool {
+			return windows[i].End.After(p.CreatedAt)
+		})
+		return lo, len(windows)
+	}
+}
+
+// bucketPulls tallies the PRs in the windows they belong to. Each PR is
+// only checked against its candidate windows, making the total work
+// roughly linear in the number of PRs rather than PRs x windows.
+func bucketPulls(config Config, pulls []PrEntry, windows []Pulse) []pulseTally {
+	tallies := make([]pulseTally, len(windows))
+	for _, p := range pulls {
+		warnInverted(p)
+		addPull(config, tallies, p, windows)
+	}
+	return tallies
+}
+
+// addPull adds a PR to the tallies of the windows it belongs to.
+func addPull(config Config, tallies []pulseTally, p PrEntry, windows []Pulse) {
+	lo, hi := candidateWindows(config, p, windows)
+	for i := lo; i < hi; i++ {
+		if pull, ok := pulsePull(config, p, windows[i].Start, windows[i].End); ok {
+			tallies[i].add(config, pull, windows[i].End)
+		}
+	}
+}
+
+// prWeight returns the weight of a PR in the normalised counts, which is
+// its size weight multiplied by its label weight.
+func prWeight(config Config, p Pull) float32 {
+	return prSizeWeight(config, pullSize(config, p)) * p.LabelWeight
+}
+
+// labelWeight returns the multiplier of the PR.LabelWeights matching the
+// labels of a PR. If several labels match, the lowest multiplier is
+// used, and if none match the multiplier is 1.
+func labelWeight(config Config, p PrEntry) float32 {
+	weight := 1.0
+	found := false
+	for _, l := range p.Labels.Nodes {
+		w, ok := config.Settings.PR.LabelWeights[l.Name]
+		if ok && (!found || w < weight) {
+			weight = w
+			found = true
+		}
+	}
+	return float32(weight)
 }
 
 func prSizeWeight(config Config, lines float32) float32 {
@@ -529,61 +3507,351 @@ func prSizeWeight(config Config, lines float32) float32 {
 	return 1.0
 }
 
-func getOpen(config Config, pulls []Pull) float32 {
-	var count float32
-	for _, p := range pulls {
-		if p.Open == true {
-			count += 1.0
+// pulseTally aggregates the PRs counted in a pulse window as they are
+// added, so the pulse metrics can be calculated without retaining the
+// PRs. Only the latencies of the merged PRs are kept, for the medians.
+type pulseTally struct {
+	open             float32
+	merged           float32
+	closed           float32
+	openWeight       float32 // Weighted open PRs, for the normalised values
+	mergedWeight     float32 // Weighted merged PRs, for the normalised values
+	authorMerged     map[string]int
+	mergedUnapproved float32
+	files            float32 // Files changed by the merged PRs
+	reverts          int
+	stale            int
+	abandoned        int
+	mega             int
+	threads          float32 // Review threads on the merged PRs
+	mergedSelf       float32
+	mergedOther      float32
+	issues           int
+	shortBodies      int
+	bodyLength       float32 // Description length of the merged PRs
+	small            int
+	medium           int
+	large            int
+	added            float32         // Lines added by the merged PRs
+	deleted          float32         // Lines deleted by the merged PRs
+	approvals        []time.Duration // Latencies of the merged PRs
+	queues           []time.Duration
+	merges           []time.Duration
+	pulls            []Pull // Only retained if needed, see retainPulls
+}
+
+// retainPulls returns whether the PRs counted in each pulse are retained
+// in Pulse.Pulls, which is only needed by the outputs listing PRs.
+func retainPulls(config Config) bool {
+	return *audit || config.Settings.PR.EmitMegaPRs || config.Settings.Contributors.EmailDomains
+}
+
+// add counts a PR classified for the window ending at end.
+func (t *pulseTally) add(config Config, p Pull, end time.Time) {
+	if retainPulls(config) {
+		t.pulls = append(t.pulls, p)
+	}
+
+	switch {
+	case p.Open:
+		t.open += 1
+		if !normExcluded(config, p.Contributor) {
+			t.openWeight += prWeight(config, p)
 		}
+		// As only the latest update time is known, PRs updated
+		// after the end of the window are never counted as stale.
+		if days := config.Settings.PR.StaleDays; days > 0 && p.Updated.Before(end.AddDate(0, 0, -days)) {
+			t.stale += 1
+		}
+	case p.Abandoned:
+		t.abandoned += 1
+	case p.Closed:
+		t.closed += 1
+	}
+	if !p.Merged {
+		return
 	}
-	return count
-}
 
-func getOpenNorm(config Config, pulls []Pull, con int) float32 {
-	var count float32
-	for _, p := range pulls {
-		if p.Open == true {
-			count += prSizeWeight(config,float32(p.Lines))
+	t.merged += 1
+	if !normExcluded(config, p.Contributor) {
+		t.mergedWeight += prWeight(config, p)
+	}
+	if p.Contributor != "" {
+		if t.authorMerged == nil {
+			t.authorMerged = make(map[string]int)
 		}
+		t.authorMerged[p.Contributor] += 1
+	}
+	if p.Approvals < config.Settings.PR.Approvals {
+		t.mergedUnapproved += 1
+	}
+	t.files += float32(p.Files)
+	t.added += float32(p.Additions)
+	t.deleted += float32(p.Deletions)
+	if p.Revert {
+		t.reverts += 1
+	}
+	if megaPR(config, p) {
+		t.mega += 1
+	}
+	t.threads += float32(p.Threads)
+	// PRs without a known merger are neither self merged nor merged
+	// by others
+	if p.MergedBy != "" && p.MergedBy == p.Author {
+		t.mergedSelf += 1
+	} else if p.MergedBy != "" {
+		t.mergedOther += 1
+	}
+	t.issues += p.Issues
+	if p.BodyLen == 0 || p.BodyLen < config.Settings.PR.MinBodyLength {
+		t.shortBodies += 1
+	}
+	t.bodyLength += float32(p.BodyLen)
+	switch prSizeWeight(config, pullSize(config, p)) {
+	case 3.0:
+		t.large += 1
+	case 2.0:
+		t.medium += 1
+	default:
+		t.small += 1
+	}
+	if p.ApprovalLatency != nil {
+		t.approvals = append(t.approvals, *p.ApprovalLatency)
+	}
+	if p.QueueLatency != nil {
+		t.queues = append(t.queues, *p.QueueLatency)
+	}
+	if p.MergeLatency != nil {
+		t.merges = append(t.merges, *p.MergeLatency)
 	}
-	if con == 0 {
+}
+
+// ratio returns a / b, or zero if b is zero.
+func ratio(a float32, b float32) float32 {
+	if b == 0 {
 		return 0.0
 	}
-	return count / float32(con)
+	return a / b
 }
 
-func getMerged(config Config, pulls []Pull) float32 {
-	var count float32
-	for _, p := range pulls {
-		if p.Merged == true {
-			count += 1.0
+// normDivisor returns the value the weighted PR counts of a pulse are
+// divided by for normalisation, given the active contributors of the
+// pulse ending at end. With the "recency" norm mode each contributor is
+// weighted by their tenure t in days at the end of the pulse, as
+// 1 - 0.5^(t / half life), so new contributors count little until they
+// have contributed for a while. The contributors in
+// Contributors.NormExclude are left out.
+func normDivisor(config Config, active map[string]bool, users map[string]User, end time.Time) float32 {
+	if config.Settings.Contributors.NormMode != "recency" {
+		count := 0
+		for login := range active {
+			if !normExcluded(config, login) {
+				count += 1
+			}
 		}
+		return float32(count)
 	}
-	return count
+	halfLife := float64(config.Settings.Contributors.HalfLife)
+	divisor := 0.0
+	for login := range active {
+		if normExcluded(config, login) {
+			continue
+		}
+		tenure := end.Sub(users[login].Start).Hours() / 24
+		if tenure < 0 {
+			tenure = 0
+		}
+		divisor += 1 - math.Pow(0.5, tenure/halfLife)
+	}
+	return float32(divisor)
 }
 
-func getMergedNorm(config Config, pulls []Pull, con int) float32 {
-	var count float32
-	for _, p := range pulls {
-		if p.Merged == true {
-			count += prSizeWeight(config, float32(p.Lines))
+// normExcluded returns true if the contributor is listed in
+// Contributors.NormExclude, which leaves their PRs and themselves out of
+// the normalised values only.
+func normExcluded(config Config, login string) bool {
+	if excludedMember(config, login) {
+		return true
+	}
+	for _, u := range config.Settings.Contributors.NormExclude {
+		if u == login {
+			return true
 		}
 	}
-	if con == 0 {
+	return false
+}
+
+// medianHours returns the median of the durations in hours, or zero if
+// there are no durations.
+func medianHours(durations []time.Duration) float32 {
+	if len(durations) == 0 {
 		return 0.0
 	}
-	return count / float32(con)
+	sort.Slice(durations, func(i, j int) bool {
+		return durations[i] < durations[j]
+	})
+	n := len(durations)
+	median := durations[n/2]
+	if n%2 == 0 {
+		median = (durations[n/2-1] + durations[n/2]) / 2
+	}
+	return float32(median.Hours())
+}
+
+// abandonedAt returns whether a PR open at end and created at created is
+// older than PR.MaxOpenAge days.
+func abandonedAt(config Config, created time.Time, end time.Time) bool {
+	if config.Settings.PR.MaxOpenAge <= 0 {
+		return false
+	}
+	return created.Before(end.AddDate(0, 0, -config.Settings.PR.MaxOpenAge))
 }
 
 type Pulse struct {
-	Start          time.Time
-	End            time.Time // Start time of the following week
-	Days           int
-	Contributors   int
-	PrOpen         float32
-	PrMerged       float32
-	PrOpenNorm     float32
-	PrMergedNorm   float32
+	Start              time.Time
+	End                time.Time // Start time of the following week
+	Days               int
+	Contributors       int
+	ContribInternal    int             // Active owners, members and collaborators
+	ContribExternal    int             // Other active contributors
+	Active             map[string]bool // Logins of the active contributors
+	Pulls              []Pull          // The PRs counted in the pulse, see retainPulls
+	PrOpen             float32
+	PrMerged           float32
+	PrOpenNorm         float32
+	PrMergedNorm       float32
+	NormDivisor        float32        // Divisor used for the normalised values
+	AuthorMerged       map[string]int // Merged PRs per author login
+	PrMergedUnapproved float32        // Merged PRs with fewer approvals than required
+	PrClosed           float32        // Closed without merging
+	AcceptanceRate     float32        // Merged / (merged + closed)
+	AvgFilesChanged    float32        // Average files changed by merged PRs
+	Reverts            float32        // Merged revert PRs
+	ApprovalHours      float32        // Median hours until first approval
+	QueueHours         float32        // Median hours in the merge queue
+	StalePRs           int            // Open PRs without recent updates
+	AbandonedOpen      int            // Open PRs older than the maximum open age
+	MegaPRs            float32        // Merged PRs with more than the mega lines
+	ReviewComments     float32        // Average review threads on merged PRs
+	PrMergedSelf       float32        // Merged by the author
+	PrMergedOther      float32        // Merged by someone other than the author
+	SelfMergeRate      float32        // Self / (self + other)
+	IssuesClosed       float32        // Issues closed by merged PRs
+	Retention          float32        // Previous pulse contributors still active
+	Partial            bool           // The pulse has not ended yet
+	ShortBodies        float32        // Merged PRs with a short description
+	AvgBodyLength      float32        // Average description length of merged PRs
+	MergeHours         float32        // Median hours until merged
+	MergedSmall        float32        // Merged PRs with size weight 1
+	MergedMedium       float32        // Merged PRs with size weight 2
+	MergedLarge        float32        // Merged PRs with size weight 3
+	LinesAdded         float32        // Lines added by merged PRs
+	LinesDeleted       float32        // Lines deleted by merged PRs
+
+	// The raw tally of the pulse, before prorating, if calculated in
+	// this run rather than read back
+	tally *pulseTally
+}
+
+// PulseMetric is a named per-pulse value which can be referenced from
+// custom metric expressions.
+type PulseMetric struct {
+	Name  string
+	Desc  string
+	Value func(p Pulse) float64
+}
+
+var pulseMetrics = []PulseMetric{
+	{"days", "Length of the pulse in days", func(p Pulse) float64 { return float64(p.Days) }},
+	{"contributors", "Active contributors", func(p Pulse) float64 { return float64(p.Contributors) }},
+	{"contributors_internal", "Active owners, members and collaborators", func(p Pulse) float64 { return float64(p.ContribInternal) }},
+	{"contributors_external", "Other active contributors", func(p Pulse) float64 { return float64(p.ContribExternal) }},
+	{"open", "Open PRs", func(p Pulse) float64 { return float64(p.PrOpen) }},
+	{"merged", "Merged PRs", func(p Pulse) float64 { return float64(p.PrMerged) }},
+	{"closed", "PRs closed without merging", func(p Pulse) float64 { return float64(p.PrClosed) }},
+	{"open_norm", "Normalised open PRs", func(p Pulse) float64 { return float64(p.PrOpenNorm) }},
+	{"merged_norm", "Normalised merged PRs", func(p Pulse) float64 { return float64(p.PrMergedNorm) }},
+	{"merged_unapproved", "Merged PRs with fewer approvals than required", func(p Pulse) float64 { return float64(p.PrMergedUnapproved) }},
+	{"acceptance", "Merged / (merged + closed)", func(p Pulse) float64 { return float64(p.AcceptanceRate) }},
+	{"files_changed_avg", "Average files changed by merged PRs", func(p Pulse) float64 { return float64(p.AvgFilesChanged) }},
+	{"reverts", "Merged revert PRs", func(p Pulse) float64 { return float64(p.Reverts) }},
+	{"approval_hours", "Median hours from creation until first approval of merged PRs", func(p Pulse) float64 { return float64(p.ApprovalHours) }},
+	{"queue_hours", "Median hours merged PRs spent in the merge queue", func(p Pulse) float64 { return float64(p.QueueHours) }},
+	{"merged_small", "Merged PRs with size weight 1", func(p Pulse) float64 { return float64(p.MergedSmall) }},
+	{"merged_medium", "Merged PRs with size weight 2", func(p Pulse) float64 { return float64(p.MergedMedium) }},
+	{"merged_large", "Merged PRs with size weight 3", func(p Pulse) float64 { return float64(p.MergedLarge) }},
+	{"merge_hours", "Median hours from creation until merged of merged PRs", func(p Pulse) float64 { return float64(p.MergeHours) }},
+	{"stale", "Open PRs not updated for more than the configured stale days", func(p Pulse) float64 { return float64(p.StalePRs) }},
+	{"abandoned", "Open PRs older than the configured maximum open age, not counted as open", func(p Pulse) float64 { return float64(p.AbandonedOpen) }},
+	{"mega_prs", "Merged PRs with more lines than the configured mega lines", func(p Pulse) float64 { return float64(p.MegaPRs) }},
+	{"review_comments_avg", "Average review threads on merged PRs", func(p Pulse) float64 { return float64(p.ReviewComments) }},
+	{"merged_self", "PRs merged by their author", func(p Pulse) float64 { return float64(p.PrMergedSelf) }},
+	{"merged_other", "PRs merged by someone other than their author", func(p Pulse) float64 { return float64(p.PrMergedOther) }},
+	{"self_merge_rate", "Self merged / (self merged + merged by others)", func(p Pulse) float64 { return float64(p.SelfMergeRate) }},
+	{"issues_closed", "Issues closed by merged PRs", func(p Pulse) float64 { return float64(p.IssuesClosed) }},
+	{"retention", "Fraction of the previous pulse contributors still active", func(p Pulse) float64 { return float64(p.Retention) }},
+	{"short_descriptions", "Merged PRs with an empty or short description", func(p Pulse) float64 { return float64(p.ShortBodies) }},
+	{"description_length_avg", "Average description length of merged PRs", func(p Pulse) float64 { return float64(p.AvgBodyLength) }},
+	{"lines_added", "Lines added by merged PRs", func(p Pulse) float64 { return float64(p.LinesAdded) }},
+	{"lines_deleted", "Lines deleted by merged PRs", func(p Pulse) float64 { return float64(p.LinesDeleted) }},
+}
+
+// pulseMetricValues returns the values of all the pulse metrics by name.
+func pulseMetricValues(p Pulse) map[string]float64 {
+	values := make(map[string]float64)
+	for _, m := range pulseMetrics {
+		values[m.Name] = m.Value(p)
+	}
+	return values
+}
+
+// listMetrics writes the name and description of every pulse metric to
+// out, which are the names usable in custom metrics and thresholds.
+func listMetrics(out io.Writer) error {
+	width := 0
+	for _, m := range pulseMetrics {
+		if len(m.Name) > width {
+			width = len(m.Name)
+		}
+	}
+	for _, m := range pulseMetrics {
+		_, err := fmt.Fprintf(out, "%-*s  %s\n", width, m.Name, m.Desc)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CustomMetric is a metric derived from the pulse metrics using an
+// expression supplied in the config.
+type CustomMetric struct {
+	Name string
+	Expr Expr
+}
+
+// customMetrics parses the configured custom metrics, sorted by name,
+// and checks that they only reference known pulse metrics.
+func customMetrics(config Config) ([]CustomMetric, error) {
+	known := pulseMetricValues(Pulse{})
+
+	metrics := make([]CustomMetric, 0)
+	for name, src := range config.Settings.CustomMetrics {
+		e, err := parseExpr(src)
+		if err != nil {
+			return nil, fmt.Errorf("custom metric %q: %w", name, err)
+		}
+		for _, v := range e.Vars(nil) {
+			if _, ok := known[v]; !ok {
+				return nil, fmt.Errorf("custom metric %q: unknown metric %q", name, v)
+			}
+		}
+		metrics = append(metrics, CustomMetric{Name: name, Expr: e})
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].Name < metrics[j].Name
+	})
+	return metrics, nil
 }
 
 func isoWeeks(year int) (weeks int) {
@@ -612,16 +3880,36 @@ func nextPulseToIsoWeek(year int, week int) (int, int) {
 	return year, week
 }
 
-func getPulses(config Config, start time.Time, end time.Time, pulls []PrEntry, users map[string]User) []Pulse {
+// pulseWindows returns the pulses between start and end, with only the
+// window (Start, End and Days) filled in.
+func pulseWindows(config Config, start time.Time, end time.Time) []Pulse {
 	if end.Before(start) {
 		panic("end time cannot before start")
 	}
 
+	var windows []Pulse
+	if config.Settings.Graphs.Cadence == "month" {
+		windows = monthWindows(start, end, reportLocation(config))
+	} else {
+		windows = isoWeekWindows(start, end)
+	}
+
+	// If the number of pulses required (Graph.Window) is less than what is available
+	// lets trim what we return.
+	if config.Settings.Graphs.Window > 0 && config.Settings.Graphs.Window < len(windows) {
+		windows = windows[len(windows)-config.Settings.Graphs.Window:]
+	}
+
+	return windows
+}
+
+// isoWeekWindows returns the 2-week pulses between start and end.
+func isoWeekWindows(start time.Time, end time.Time) []Pulse {
 	// For now assume 2-week pulses start on the 1st ISO week of the year
 	yearStart, weekStart := start.ISOWeek()
 	weekStart = isoWeekToPulseStart(weekStart)
 
-	pulses := make([]Pulse, 0)
+	windows := make([]Pulse, 0)
 	for {
 		s := isoweek.StartTime(yearStart, weekStart, time.UTC)
 		yearEnd, weekEnd := nextPulseToIsoWeek(yearStart, weekStart)
@@ -631,29 +3919,241 @@ func getPulses(config Config, start time.Time, end time.Time, pulls []PrEntry, u
 			break
 		}
 
-		people := pulseContributors(config, users, s, e)
-		pulsePulls := pulsePulls(config, pulls, s, e)
-
-		pulses = append(pulses, Pulse{
-			Start:          s,
-			End:            e,
-			Days:           d,
-			Contributors:   people,
-			PrOpen:         getOpen(config, pulsePulls),
-			PrMerged:       getMerged(config, pulsePulls),
-			PrOpenNorm:     getOpenNorm(config, pulsePulls, people),
-			PrMergedNorm:   getMergedNorm(config, pulsePulls, people),
+		windows = append(windows, Pulse{
+			Start: s,
+			End:   e,
+			Days:  d,
 		})
 
 		yearStart = yearEnd
 		weekStart = weekEnd
 	}
+	return windows
+}
 
-	// If the number of pulses required (Graph.Window) is less than what is available
-	// lets trim what we return.
-	if config.Settings.Graphs.Window > 0 {
-		pulses = pulses[len(pulses) - config.Settings.Graphs.Window:]
+// monthWindows returns the calendar month pulses between start and end,
+// in the report location so the months match their labels.
+func monthWindows(start time.Time, end time.Time, loc *time.Location) []Pulse {
+	y, m, _ := start.In(loc).Date()
+	windows := make([]Pulse, 0)
+	for s := time.Date(y, m, 1, 0, 0, 0, 0, loc); !s.After(end); m++ {
+		e := time.Date(y, m+1, 1, 0, 0, 0, 0, loc)
+		windows = append(windows, Pulse{
+			Start: s,
+			End:   e,
+			// Counted in calendar days, as a DST change makes the
+			// month an hour longer or shorter
+			Days: time.Date(y, m+1, 0, 0, 0, 0, 0, time.UTC).Day(),
+		})
+		s = e
+	}
+	return windows
+}
+
+// newPulse calculates the metrics of a pulse window from the tally of
+// the pulls in the window.
+func newPulse(config Config, w Pulse, t *pulseTally, users map[string]User) Pulse {
+	active := activeContributors(config, users, w.Start, w.End)
+	people := len(active)
+	internal := internalCount(active, users)
+	divisor := normDivisor(config, active, users, w.End)
+
+	authorMerged := t.authorMerged
+	if authorMerged == nil {
+		authorMerged = make(map[string]int)
+	}
+	p := Pulse{
+		Start:              w.Start,
+		End:                w.End,
+		Days:               w.Days,
+		Contributors:       people,
+		ContribInternal:    internal,
+		ContribExternal:    people - internal,
+		Active:             active,
+		Pulls:              t.pulls,
+		tally:              t,
+		PrOpen:             t.open,
+		PrMerged:           t.merged,
+		PrOpenNorm:         ratio(t.openWeight, divisor),
+		PrMergedNorm:       ratio(t.mergedWeight, divisor),
+		NormDivisor:        divisor,
+		AuthorMerged:       authorMerged,
+		PrMergedUnapproved: t.mergedUnapproved,
+		PrClosed:           t.closed,
+		AcceptanceRate:     ratio(t.merged, t.merged+t.closed),
+		AvgFilesChanged:    ratio(t.files, t.merged),
+		Reverts:            float32(t.reverts),
+		ApprovalHours:      medianHours(t.approvals),
+		QueueHours:         medianHours(t.queues),
+		MergeHours:         medianHours(t.merges),
+		StalePRs:           t.stale,
+		AbandonedOpen:      t.abandoned,
+		MegaPRs:            float32(t.mega),
+		ReviewComments:     ratio(t.threads, t.merged),
+		PrMergedSelf:       t.mergedSelf,
+		PrMergedOther:      t.mergedOther,
+		SelfMergeRate:      ratio(t.mergedSelf, t.mergedSelf+t.mergedOther),
+		IssuesClosed:       float32(t.issues),
+		Partial:            now.Before(w.End),
+		ShortBodies:        float32(t.shortBodies),
+		AvgBodyLength:      ratio(t.bodyLength, t.merged),
+		MergedSmall:        float32(t.small),
+		MergedMedium:       float32(t.medium),
+		MergedLarge:        float32(t.large),
+		LinesAdded:         t.added,
+		LinesDeleted:       t.deleted,
+	}
+	if p.Partial && config.Settings.Graphs.ProrateCurrent {
+		prorate(&p, now)
+	}
+	return p
+}
+
+// prorate scales the flow metrics (counts of PRs reaching a state during
+// the pulse) of a partial pulse to the full pulse length, based on the
+// time elapsed. At least a day is considered elapsed, to avoid inflating
+// the first hours of a pulse.
+func prorate(p *Pulse, now time.Time) {
+	elapsed := float32(now.Sub(p.Start).Hours() / 24)
+	if elapsed < 1 {
+		elapsed = 1
+	}
+	if elapsed >= float32(p.Days) {
+		return
+	}
+	factor := float32(p.Days) / elapsed
+
+	p.PrMerged *= factor
+	p.PrMergedNorm *= factor
+	p.PrClosed *= factor
+	p.PrMergedUnapproved *= factor
+	p.PrMergedSelf *= factor
+	p.PrMergedOther *= factor
+	p.Reverts *= factor
+	p.IssuesClosed *= factor
+	p.MergedSmall *= factor
+	p.MergedMedium *= factor
+	p.MergedLarge *= factor
+	p.MegaPRs *= factor
+	p.ShortBodies *= factor
+	p.LinesAdded *= factor
+	p.LinesDeleted *= factor
+}
+
+func getPulses(config Config, start time.Time, end time.Time, pulls []PrEntry, users map[string]User) []Pulse {
+	windows := pulseWindows(config, start, end)
+	tallies := bucketPulls(config, pulls, windows)
+
+	pulses := make([]Pulse, 0, len(windows))
+	for i, w := range windows {
+		pulses = append(pulses, newPulse(config, w, &tallies[i], users))
 	}
+	setRetention(pulses)
+	return pulses
+}
+
+// orderPulses sorts the pulses by their start, keeping the order of
+// pulses with the same start, and checks that the starts are strictly
+// increasing. The windows are created in order, so a failure points at
+// a bug in the windowing or a pulse hook.
+func orderPulses(pulses []Pulse) error {
+	sort.SliceStable(pulses, func(i, j int) bool {
+		return pulses[i].Start.Before(pulses[j].Start)
+	})
+	for i := 1; i < len(pulses); i++ {
+		if !pulses[i].Start.After(pulses[i-1].Start) {
+			return fmt.Errorf("pulses %d and %d both start at %s", i-1, i, pulses[i].Start.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// setRetention sets the fraction of the contributors active in the
+// previous pulse that are still active in each pulse. As the first pulse
+// has no previous pulse, its retention is zero.
+func setRetention(pulses []Pulse) {
+	for i := 1; i < len(pulses); i++ {
+		prev := pulses[i-1].Active
+		if len(prev) == 0 {
+			continue
+		}
+		retained := 0
+		for login := range prev {
+			if pulses[i].Active[login] {
+				retained += 1
+			}
+		}
+		pulses[i].Retention = float32(retained) / float32(len(prev))
+	}
+}
+
+// PulseAccumulator incrementally tallies the pulls of each pulse window
+// and collects the contributors, so PRs do not have to be retained once
+// added. This bounds memory use in streaming mode.
+type PulseAccumulator struct {
+	config  Config
+	windows []Pulse
+	tallies []pulseTally
+	users   map[string]User
+	summary RepoSummary
+	rows    []PrRow // Only kept for the SQLite database
+}
+
+func newPulseAccumulator(config Config, start time.Time, end time.Time) *PulseAccumulator {
+	a := &PulseAccumulator{
+		config:  config,
+		windows: pulseWindows(config, start, end),
+	}
+	a.Reset()
+	return a
+}
+
+// Reset discards all the PRs added, so the history can be read again.
+func (a *PulseAccumulator) Reset() {
+	a.tallies = make([]pulseTally, len(a.windows))
+	a.users = make(map[string]User)
+	a.summary = RepoSummary{}
+	a.rows = nil
+}
+
+// AddUser adds a PR to the contributors only, without counting it in
+// the windows.
+func (a *PulseAccumulator) AddUser(p PrEntry) {
+	addUser(a.config, a.users, p)
+}
 
+// Add adds a PR to the contributors and to every window it belongs to.
+func (a *PulseAccumulator) Add(p PrEntry) {
+	addUser(a.config, a.users, p)
+	warnInverted(p)
+	addPull(a.config, a.tallies, p, a.windows)
+	a.summary.add(a.config, p)
+	if a.config.Settings.SQLite != "" {
+		a.rows = append(a.rows, newPrRow(p))
+	}
+}
+
+// Summary returns the summary of all the PRs added.
+func (a *PulseAccumulator) Summary() RepoSummary {
+	dropDriveBy(a.config, a.users)
+	s := a.summary
+	s.setContributors(a.config, a.users)
+	return s
+}
+
+// Users returns the contributors of all the PRs added.
+func (a *PulseAccumulator) Users() map[string]User {
+	dropDriveBy(a.config, a.users)
+	return a.users
+}
+
+// Pulses calculates the pulse metrics of all the PRs added.
+func (a *PulseAccumulator) Pulses() []Pulse {
+	dropDriveBy(a.config, a.users)
+	pulses := make([]Pulse, 0, len(a.windows))
+	for i, w := range a.windows {
+		pulses = append(pulses, newPulse(a.config, w, &a.tallies[i], a.users))
+	}
+	setRetention(pulses)
 	return pulses
 }
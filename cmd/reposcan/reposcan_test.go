@@ -0,0 +1,1141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shurcooL/githubv4"
+)
+
+// testNow is the fixed clock of the tests, in the middle of an ISO week.
+var testNow = time.Date(2024, 3, 20, 12, 0, 0, 0, time.UTC)
+
+// testConfig returns a config with the metrics exercised by the tests
+// enabled.
+func testConfig() Config {
+	var config Config
+	config.Settings.PR.High = 500
+	config.Settings.PR.Low = 100
+	config.Settings.PR.Approvals = 1
+	config.Settings.PR.StaleDays = 14
+	config.Settings.PR.MaxOpenAge = 90
+	config.Settings.PR.MegaLines = 1500
+	config.Settings.PR.MinBodyLength = 10
+	config.Settings.Contributors.Cooldown = 2
+	return config
+}
+
+// testPulls returns n random PRs created in the year before testNow, by
+// a few dozen authors, in a mix of states.
+func testPulls(n int, seed int64) []PrEntry {
+	r := rand.New(rand.NewSource(seed))
+	history := 365 * 24 * time.Hour
+	pulls := make([]PrEntry, 0, n)
+	for i := 0; i < n; i++ {
+		var p PrEntry
+		p.Number = i + 1
+		p.Repository.NameWithOwner = "org/repo"
+		p.Author.Login = fmt.Sprintf("user%d", r.Intn(40))
+		p.AuthorAssociation = "CONTRIBUTOR"
+		p.Additions = r.Intn(1000)
+		p.Deletions = r.Intn(1000)
+		p.ChangedFiles = 1 + r.Intn(30)
+		p.Body = "A description of the change"[:r.Intn(28)]
+		p.CreatedAt = testNow.Add(-time.Duration(r.Int63n(int64(history))))
+
+		age := testNow.Sub(p.CreatedAt)
+		closed := p.CreatedAt.Add(time.Duration(r.Int63n(int64(age))))
+		switch r.Intn(4) {
+		case 0:
+			p.State = "OPEN"
+			p.UpdatedAt = closed
+		case 1:
+			p.State = "CLOSED"
+			p.ClosedAt = &closed
+			p.UpdatedAt = closed
+		default:
+			p.State = "MERGED"
+			p.ClosedAt = &closed
+			p.MergedAt = &closed
+			p.UpdatedAt = closed
+			p.MergedBy.Login = p.Author.Login
+			if r.Intn(2) == 0 {
+				p.MergedBy.Login = "maintainer"
+				approved := p.CreatedAt.Add(closed.Sub(p.CreatedAt) / 2)
+				p.Approvals.TotalCount = 1
+				p.Approvals.Nodes = append(p.Approvals.Nodes, struct {
+					SubmittedAt *time.Time
+				}{&approved})
+			}
+		}
+		pulls = append(pulls, p)
+	}
+	return pulls
+}
+
+// setTestNow replaces the clock for the duration of a test.
+func setTestNow(tb testing.TB) {
+	saved := now
+	now = testNow
+	tb.Cleanup(func() { now = saved })
+}
+
+func BenchmarkGetPulses(b *testing.B) {
+	setTestNow(b)
+	config := testConfig()
+	pulls := testPulls(50000, 1)
+	users := getUsers(config, pulls, nil)
+	start := testNow.AddDate(-1, 0, 0)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		getPulses(config, start, testNow, pulls, users)
+	}
+}
+
+func BenchmarkBucketPulls(b *testing.B) {
+	setTestNow(b)
+	config := testConfig()
+	pulls := testPulls(50000, 1)
+	windows := pulseWindows(config, testNow.AddDate(-1, 0, 0), testNow)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bucketPulls(config, pulls, windows)
+	}
+}
+
+func BenchmarkPulsePull(b *testing.B) {
+	setTestNow(b)
+	config := testConfig()
+	pulls := testPulls(50000, 1)
+	windows := pulseWindows(config, testNow.AddDate(-1, 0, 0), testNow)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p := pulls[i%len(pulls)]
+		w := windows[i%len(windows)]
+		pulsePull(config, p, w.Start, w.End)
+	}
+}
+
+// naivePulses calculates the pulses by checking every PR against every
+// window, which is what bucketPulls must be equivalent to.
+func naivePulses(config Config, start time.Time, end time.Time, pulls []PrEntry, users map[string]User) []Pulse {
+	windows := pulseWindows(config, start, end)
+	pulses := make([]Pulse, 0, len(windows))
+	for _, w := range windows {
+		var t pulseTally
+		for _, p := range pulls {
+			if pull, ok := pulsePull(config, p, w.Start, w.End); ok {
+				t.add(config, pull, w.End)
+			}
+		}
+		pulses = append(pulses, newPulse(config, w, &t, users))
+	}
+	setRetention(pulses)
+	return pulses
+}
+
+func TestGetPulsesMatchesNaive(t *testing.T) {
+	setTestNow(t)
+	for _, cadence := range []string{"", "month"} {
+		for _, basis := range []string{"", "created", "merged"} {
+			for seed := int64(1); seed <= 5; seed++ {
+				config := testConfig()
+				config.Settings.Graphs.Cadence = cadence
+				config.Settings.Graphs.WindowBasis = basis
+				pulls := testPulls(500, seed)
+				users := getUsers(config, pulls, nil)
+				start := testNow.AddDate(0, -6, 0)
+
+				got := getPulses(config, start, testNow, pulls, users)
+				want := naivePulses(config, start, testNow, pulls, users)
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("cadence %q, basis %q, seed %d: pulses differ from the naive scan", cadence, basis, seed)
+				}
+			}
+		}
+	}
+}
+
+func TestOverlapsWindow(t *testing.T) {
+	start := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 7)
+	at := func(days int) *time.Time {
+		t := start.AddDate(0, 0, days)
+		return &t
+	}
+
+	tests := []struct {
+		name    string
+		created time.Time
+		closed  *time.Time
+		want    bool
+	}{
+		{"open since before", *at(-30), nil, true},
+		{"open since during", *at(3), nil, true},
+		{"created at end", end, nil, false},
+		{"created after", *at(10), nil, false},
+		{"closed during", *at(-30), at(3), true},
+		{"closed at start", *at(-30), &start, true},
+		{"closed before start", *at(-30), at(-1), false},
+		{"closed long ago", *at(-365), at(-300), false},
+		{"closed after", *at(-30), at(10), true},
+		{"created and closed during", *at(1), at(2), true},
+	}
+	for _, tt := range tests {
+		p := PrEntry{CreatedAt: tt.created, ClosedAt: tt.closed}
+		if got := overlapsWindow(p, start, end); got != tt.want {
+			t.Errorf("%s: overlapsWindow() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPulsePullWindowBasis(t *testing.T) {
+	week := func(n int) (time.Time, time.Time) {
+		start := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC).AddDate(0, 0, 7*n)
+		return start, start.AddDate(0, 0, 7)
+	}
+	created, _ := week(0)
+	created = created.Add(24 * time.Hour)
+	merged, _ := week(2)
+	merged = merged.Add(24 * time.Hour)
+
+	var p PrEntry
+	p.Number = 1
+	p.State = "MERGED"
+	p.CreatedAt = created
+	p.ClosedAt = &merged
+	p.MergedAt = &merged
+
+	// The weeks the PR belongs to under each basis, out of weeks 0-3
+	tests := []struct {
+		basis string
+		want  []bool
+	}{
+		{"", []bool{false, false, true, false}},
+		{"activity", []bool{false, false, true, false}},
+		{"created", []bool{true, false, false, false}},
+		{"merged", []bool{false, false, true, false}},
+	}
+	for _, tt := range tests {
+		config := testConfig()
+		config.Settings.Graphs.WindowBasis = tt.basis
+		for n, want := range tt.want {
+			start, end := week(n)
+			if _, got := pulsePull(config, p, start, end); got != want {
+				t.Errorf("basis %q, week %d: pulsePull() = %v, want %v", tt.basis, n, got, want)
+			}
+		}
+	}
+
+	// Under the activity basis an open PR belongs to every week since
+	// it was created, and under the merged basis to none.
+	p.State = "OPEN"
+	p.ClosedAt = nil
+	p.MergedAt = nil
+	for _, tt := range []struct {
+		basis string
+		want  []bool
+	}{
+		{"activity", []bool{true, true, true, true}},
+		{"created", []bool{true, false, false, false}},
+		{"merged", []bool{false, false, false, false}},
+	} {
+		config := testConfig()
+		config.Settings.Graphs.WindowBasis = tt.basis
+		for n, want := range tt.want {
+			start, end := week(n)
+			if _, got := pulsePull(config, p, start, end); got != want {
+				t.Errorf("open, basis %q, week %d: pulsePull() = %v, want %v", tt.basis, n, got, want)
+			}
+		}
+	}
+}
+
+func TestPageGuardStuck(t *testing.T) {
+	type page struct {
+		nodes  int
+		cursor githubv4.String
+	}
+	tests := []struct {
+		name  string
+		pages []page
+		want  int // Index of the page reported stuck, or -1
+	}{
+		{"advancing", []page{{100, "a"}, {100, "b"}, {50, "c"}}, -1},
+		{"single empty page", []page{{100, "a"}, {0, "b"}, {100, "c"}}, -1},
+		{"single repeated cursor", []page{{100, "a"}, {100, "a"}, {100, "b"}}, -1},
+		{"empty pages", []page{{100, "a"}, {0, "b"}, {0, "c"}}, 2},
+		{"stuck cursor", []page{{100, "a"}, {100, "a"}, {100, "a"}}, 2},
+		{"empty and stuck", []page{{0, "a"}, {0, "a"}, {0, "a"}}, 1},
+	}
+	for _, tt := range tests {
+		var g pageGuard
+		got := -1
+		for i, p := range tt.pages {
+			if g.Stuck(p.nodes, p.cursor) {
+				got = i
+				break
+			}
+		}
+		if got != tt.want {
+			t.Errorf("%s: stuck at page %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRepoPullsScanStuck(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes string
+		want  int // Number of pages requested
+	}{
+		{"empty pages", ``, 2},
+		{"stuck cursor", `{"number": 1}`, 3},
+	}
+	for _, tt := range tests {
+		// A source which keeps reporting more pages with the same cursor
+		requests := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests += 1
+			if requests > 10 {
+				http.Error(w, "pagination did not end", http.StatusInternalServerError)
+				return
+			}
+			fmt.Fprintf(w, `{"data": {"repository": {"pullRequests": {
+				"nodes": [%s],
+				"pageInfo": {"endCursor": "stuck", "hasNextPage": true},
+				"totalCount": 1000
+			}}, "rateLimit": {"cost": 1}}}`, tt.nodes)
+		}))
+
+		client := githubv4.NewEnterpriseClient(srv.URL, srv.Client())
+		var stats FetchStats
+		var err error
+		out := captureStdout(t, func() {
+			_, _, err = repoPullsScan(context.Background(), client, testConfig(), "org", "a", nil, &stats, func(RepoEntry) {})
+		})
+		srv.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if requests != tt.want {
+			t.Errorf("%s: %d pages requested, want %d", tt.name, requests, tt.want)
+		}
+		if !strings.Contains(out, "org/a: warning: pr history pagination is not advancing, stopping early\n") {
+			t.Errorf("%s: no warning in %q", tt.name, out)
+		}
+	}
+}
+
+func TestFixedClockPulses(t *testing.T) {
+	setTestNow(t)
+	config := testConfig()
+	pulls := testPulls(300, 3)
+	start := testNow.AddDate(0, -3, 0)
+
+	run := func(config Config) []Pulse {
+		users := getUsers(config, pulls, nil)
+		return getPulses(config, start, graphsEnd(config), pulls, users)
+	}
+	first := run(config)
+	if again := run(config); !reflect.DeepEqual(first, again) {
+		t.Fatal("pulses differ between runs with the same clock")
+	}
+
+	// The default one day padding ends the pulses in the week of the
+	// clock, which is still partial.
+	last := first[len(first)-1]
+	if !inWindow(testNow, last.Start, last.End) || !last.Partial {
+		t.Errorf("last pulse %s - %s (partial %v) does not hold the clock %s", last.Start, last.End, last.Partial, testNow)
+	}
+
+	// A padding reaching into the following week adds a pulse.
+	pad := 7
+	config.Settings.Graphs.EndPadding = &pad
+	if padded := run(config); len(padded) != len(first)+1 {
+		t.Errorf("%d pulses with %d days padding, want %d", len(padded), pad, len(first)+1)
+	}
+}
+
+func TestBusinessDuration(t *testing.T) {
+	// Friday 15 March 2024 and the following Monday
+	friday := func(hour int) time.Time { return time.Date(2024, 3, 15, hour, 0, 0, 0, time.UTC) }
+	monday := func(hour int) time.Time { return time.Date(2024, 3, 18, hour, 0, 0, 0, time.UTC) }
+	day := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name     string
+		start    time.Time
+		end      time.Time
+		holidays []time.Time
+		want     time.Duration
+	}{
+		{"friday evening to monday morning", friday(17), monday(9), nil, 7*time.Hour + 9*time.Hour},
+		{"friday midnight to monday midnight", friday(0), monday(0), nil, 24 * time.Hour},
+		{"within friday", friday(9), friday(17), nil, 8 * time.Hour},
+		{"saturday to sunday", day(2024, 3, 16).Add(10 * time.Hour), day(2024, 3, 17).Add(10 * time.Hour), nil, 0},
+		{"friday to the following monday", friday(12), monday(12).AddDate(0, 0, 7), nil, 12*time.Hour + 5*24*time.Hour + 12*time.Hour},
+		{"friday holiday", friday(17), monday(9), []time.Time{day(2024, 3, 15)}, 9 * time.Hour},
+		{"monday holiday", friday(17), monday(9), []time.Time{day(2024, 3, 18)}, 7 * time.Hour},
+		{"empty", friday(9), friday(9), nil, 0},
+	}
+	for _, tt := range tests {
+		if got := businessDuration(tt.start, tt.end, tt.holidays); got != tt.want {
+			t.Errorf("%s: businessDuration() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	// Days are calendar days in the location of the start, so a Friday
+	// evening in New York is not part of the UTC weekend.
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no timezone database:", err)
+	}
+	start := time.Date(2024, 3, 15, 20, 0, 0, 0, ny)
+	end := time.Date(2024, 3, 18, 8, 0, 0, 0, ny)
+	if got, want := businessDuration(start, end, nil), 4*time.Hour+8*time.Hour; got != want {
+		t.Errorf("new york: businessDuration() = %s, want %s", got, want)
+	}
+}
+
+// captureStdout returns what f prints to stdout.
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = saved }()
+
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	f()
+	w.Close()
+	return <-out
+}
+
+func TestInvertedTimes(t *testing.T) {
+	setTestNow(t)
+	config := testConfig()
+	start := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	at := func(hours int) *time.Time {
+		t := start.Add(time.Duration(hours) * time.Hour)
+		return &t
+	}
+	merged := func(number int, created *time.Time, approved *time.Time, merged *time.Time) PrEntry {
+		var p PrEntry
+		p.Number = number
+		p.Repository.NameWithOwner = "org/repo"
+		p.Author.Login = "author"
+		p.State = "MERGED"
+		p.CreatedAt = *created
+		p.UpdatedAt = *merged
+		p.ClosedAt = merged
+		p.MergedAt = merged
+		p.Approvals.TotalCount = 1
+		p.Approvals.Nodes = append(p.Approvals.Nodes, struct {
+			SubmittedAt *time.Time
+		}{approved})
+		return p
+	}
+	pulls := []PrEntry{
+		merged(1, at(24), at(36), at(72)),
+		// Merged a day before it was created
+		merged(2, at(48), at(60), at(24)),
+	}
+
+	var pulses []Pulse
+	out := captureStdout(t, func() {
+		users := getUsers(config, pulls, nil)
+		pulses = getPulses(config, start, start.AddDate(0, 0, 6), pulls, users)
+	})
+	if n := strings.Count(out, "merged before it was created"); n != 1 {
+		t.Errorf("warned %d times about the inverted pr, want once:\n%s", n, out)
+	}
+	if !strings.Contains(out, "pr #2") || !strings.Contains(out, "approval and merge latencies") {
+		t.Errorf("warning does not name the pr and its latencies:\n%s", out)
+	}
+
+	if len(pulses) != 1 {
+		t.Fatalf("%d pulses, want 1", len(pulses))
+	}
+	p := pulses[0]
+	// The inverted PR is still counted as merged, only not in latencies
+	if p.PrMerged != 2 {
+		t.Errorf("PrMerged = %v, want 2", p.PrMerged)
+	}
+	if p.MergeHours != 48 {
+		t.Errorf("MergeHours = %v, want 48 from the valid pr only", p.MergeHours)
+	}
+	if p.ApprovalHours != 12 {
+		t.Errorf("ApprovalHours = %v, want 12 from the valid pr only", p.ApprovalHours)
+	}
+}
+
+func TestCompareMissingRepo(t *testing.T) {
+	config := testConfig()
+	config.Repos = []string{"org/a", "org/missing", "org/b"}
+	start := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	repos := map[string]*Repo{
+		"org/a": {pulses: []Pulse{{Start: start, PrMerged: 1}}},
+		"org/b": {pulses: []Pulse{{Start: start, PrMerged: 3}}},
+	}
+
+	for _, sort := range []string{"", "value"} {
+		config.Settings.Graphs.CompareSort = sort
+		var order []string
+		out := captureStdout(t, func() {
+			order = compareOrder(config, repos, "merged-abs")
+		})
+		want := []string{"org/a", "org/b"}
+		if sort == "value" {
+			want = []string{"org/b", "org/a"}
+		}
+		if !reflect.DeepEqual(order, want) {
+			t.Errorf("sort %q: compareOrder() = %v, want %v", sort, order, want)
+		}
+		if !strings.Contains(out, "org/missing: warning") {
+			t.Errorf("sort %q: no warning about the missing repo:\n%s", sort, out)
+		}
+	}
+
+	var buf bytes.Buffer
+	captureStdout(t, func() {
+		if err := writeCompareGraph(&buf, config, repos, "merged-abs", "merged"); err != nil {
+			t.Error(err)
+		}
+	})
+	if strings.Contains(buf.String(), "org/missing") {
+		t.Errorf("missing repo in the comparison graph:\n%s", buf.String())
+	}
+}
+
+func TestOrderPulses(t *testing.T) {
+	setTestNow(t)
+
+	// The windows of every cadence and range start strictly increasing
+	for _, cadence := range []string{"", "month"} {
+		config := testConfig()
+		config.Settings.Graphs.Cadence = cadence
+		for _, months := range []int{1, 6, 25} {
+			start := testNow.AddDate(0, -months, 0)
+			pulls := testPulls(200, int64(months))
+			pulses := getPulses(config, start, graphsEnd(config), pulls, getUsers(config, pulls, nil))
+			if err := orderPulses(pulses); err != nil {
+				t.Errorf("cadence %q, %d months: %v", cadence, months, err)
+			}
+			for i := 1; i < len(pulses); i++ {
+				if !pulses[i].Start.After(pulses[i-1].Start) {
+					t.Errorf("cadence %q, %d months: pulse %d starts at %s, not after %s", cadence, months, i, pulses[i].Start, pulses[i-1].Start)
+				}
+			}
+		}
+	}
+
+	// Out of order pulses are sorted
+	week := func(n int) time.Time { return time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC).AddDate(0, 0, 7*n) }
+	pulses := []Pulse{{Start: week(2)}, {Start: week(0)}, {Start: week(1)}}
+	if err := orderPulses(pulses); err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range pulses {
+		if !p.Start.Equal(week(i)) {
+			t.Errorf("pulse %d starts at %s, want %s", i, p.Start, week(i))
+		}
+	}
+
+	// Duplicate starts are an error
+	pulses = []Pulse{{Start: week(0)}, {Start: week(1)}, {Start: week(1)}}
+	if err := orderPulses(pulses); err == nil {
+		t.Error("no error for pulses with the same start")
+	}
+}
+
+func TestAppendPRGraph(t *testing.T) {
+	config := testConfig()
+	config.Settings.Graphs.PulseIndex = true
+	week := func(n int) time.Time { return time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC).AddDate(0, 0, 7*n) }
+
+	// Written in week 1, while it was in progress
+	var old bytes.Buffer
+	if err := writePRGraph(&old, config, nil, "org", "repo", []Pulse{
+		{Start: week(0), PrMerged: 1},
+		{Start: week(1), PrMerged: 2, Partial: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	name := t.TempDir() + "/graph.csv"
+	if err := os.WriteFile(name, old.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := readPRGraph(name, config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("%d rows kept, want the title, header and complete pulse", len(rows))
+	}
+
+	// The current run only covers weeks 1 and 2
+	var buf bytes.Buffer
+	if err := appendPRGraph(&buf, config, nil, rows, []Pulse{
+		{Start: week(1), PrMerged: 5},
+		{Start: week(2), PrMerged: 6, Partial: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("%d lines, want 5:\n%s", len(lines), buf.String())
+	}
+	for i, want := range []string{"2024-03-04,0,0,0.00,1.00,", "2024-03-11,1,0,0.00,5.00,", "2024-03-18,2,0,0.00,6.00,"} {
+		if !strings.HasPrefix(lines[i+2], want) {
+			t.Errorf("row %d is %q, want it to start with %q", i, lines[i+2], want)
+		}
+	}
+}
+
+func TestMonthWindowsReportTZ(t *testing.T) {
+	if _, err := time.LoadLocation("America/New_York"); err != nil {
+		t.Skip("no timezone database:", err)
+	}
+	config := testConfig()
+	config.Settings.Graphs.Cadence = "month"
+	config.Settings.Graphs.ReportTZ = "America/New_York"
+	loc := reportLocation(config)
+
+	start := time.Date(2023, 11, 15, 0, 0, 0, 0, loc)
+	end := time.Date(2024, 3, 20, 0, 0, 0, 0, loc)
+	windows := pulseWindows(config, start, end)
+
+	labels := []string{"2023-11", "2023-12", "2024-01", "2024-02", "2024-03"}
+	days := []int{30, 31, 31, 29, 31}
+	if len(windows) != len(labels) {
+		t.Fatalf("%d windows, want %d", len(windows), len(labels))
+	}
+	for i, w := range windows {
+		if got := pulseDate(config, w.Start); got != labels[i] {
+			t.Errorf("window %d labelled %s, want %s", i, got, labels[i])
+		}
+		if w.Days != days[i] {
+			t.Errorf("window %d has %d days, want %d", i, w.Days, days[i])
+		}
+		if i > 0 && !w.Start.Equal(windows[i-1].End) {
+			t.Errorf("window %d starts at %s, not at the end of the previous window", i, w.Start)
+		}
+	}
+
+	// A PR created on the evening of the last day of a month in New
+	// York, which is already the next month in UTC
+	created := time.Date(2024, 1, 31, 21, 0, 0, 0, loc)
+	if i := windowIndex(windows, created); i != 2 {
+		t.Errorf("pr created %s in window %d, want the January window", created, i)
+	}
+}
+
+func TestOmittedCustomMetric(t *testing.T) {
+	config := testConfig()
+	config.Settings.Fetch.OmitFields = []string{"reviews"}
+	config.Settings.CustomMetrics = map[string]string{"unapproved_share": "merged_unapproved / merged"}
+	var err error
+	captureStdout(t, func() {
+		err = validateOmitFields(config)
+	})
+	if err == nil || !strings.Contains(err.Error(), "unapproved_share") {
+		t.Errorf("custom metric on an omitted metric accepted, error %v", err)
+	}
+
+	config.Settings.CustomMetrics = map[string]string{"merged_per_contributor": "merged / contributors"}
+	captureStdout(t, func() {
+		err = validateOmitFields(config)
+	})
+	if err != nil {
+		t.Errorf("custom metric on fetched metrics rejected: %v", err)
+	}
+}
+
+func TestTopContributorOutputs(t *testing.T) {
+	config := testConfig()
+	config.Settings.Contributors.TopContributors = 1
+	config.Repos = []string{"org/a"}
+	start := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	repos := map[string]*Repo{
+		"org/a": {pulses: []Pulse{{
+			Start:        start,
+			End:          start.AddDate(0, 0, 7),
+			Active:       map[string]bool{"alice": true, "bob": true, "carol": true},
+			AuthorMerged: map[string]int{"alice": 5, "bob": 2, "carol": 1},
+			Pulls: []Pull{
+				{Number: 1, Contributor: "alice", Merged: true},
+				{Number: 2, Contributor: "bob", Merged: true},
+				{Number: 3, Merged: true},
+			},
+		}}},
+	}
+	top := topContributors(config, repos)
+
+	var b bytes.Buffer
+	if err := writePerContributorThroughput(&b, config, repos, top, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := "Repo,Login,Merged,Active Pulses,Merged per Active Pulse\n" +
+		"org/a,alice,5,1,5.00\n" +
+		"org/a,(others),3,2,1.50\n"
+	if b.String() != want {
+		t.Errorf("throughput:\n%s\nwant:\n%s", b.String(), want)
+	}
+
+	details := map[string]*UserDetail{
+		"alice": {PRs: 6, RepoPRs: map[string]int{"org/a": 6}},
+		"bob":   {PRs: 3, RepoPRs: map[string]int{"org/a": 3}},
+		"carol": {PRs: 1, RepoPRs: map[string]int{"org/a": 1}},
+	}
+	b.Reset()
+	if err := writeUsersMatrix(&b, config, details, top, nil); err != nil {
+		t.Fatal(err)
+	}
+	want = "Login,org/a,Total\nalice,6,6\n(others),4,4\n"
+	if b.String() != want {
+		t.Errorf("users matrix:\n%s\nwant:\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	if err := writeAudit(&b, config, repos["org/a"].pulses, top, nil); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	contributors := make([]string, 0)
+	for _, line := range lines[1:] {
+		fields := strings.Split(line, ",")
+		contributors = append(contributors, fields[len(fields)-2])
+	}
+	if want := []string{"alice", "(others)", ""}; !reflect.DeepEqual(contributors, want) {
+		t.Errorf("audit contributors %q, want %q", contributors, want)
+	}
+
+	users := map[string]User{
+		"alice": {Start: start, End: start.AddDate(0, 0, 1), PRs: 6},
+		"bob":   {Start: start.AddDate(0, 0, 1), End: start.AddDate(0, 0, 2), PRs: 3},
+		"carol": {Start: start.AddDate(0, 0, 2), End: start.AddDate(0, 0, 3), PRs: 1},
+	}
+	b.Reset()
+	if err := writeUsers(&b, users, top, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Login\nalice\n(others)\n"; b.String() != want {
+		t.Errorf("users:\n%s\nwant:\n%s", b.String(), want)
+	}
+
+	details["carol"].RepoPRs = map[string]int{"org/b": 1}
+	config.Repos = []string{"org/a", "org/b"}
+	b.Reset()
+	if err := writeUsersDetailed(&b, config, details, top, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Login,Repos,Repo List,PRs\nalice,1,org/a,6\n(others),2,org/a org/b,4\n"; b.String() != want {
+		t.Errorf("detailed users:\n%s\nwant:\n%s", b.String(), want)
+	}
+	config.Repos = []string{"org/a"}
+
+	repos["org/a"].prs = []PrEntry{testReviewedPR(start, "alice", "bob", "carol")}
+	b.Reset()
+	if err := writeReviewerTurnaround(&b, config, repos, start, top, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Login,Reviews,Turnaround Hours (Median)\nalice,1,2.00\n(others),2,2.00\n"; b.String() != want {
+		t.Errorf("turnaround:\n%s\nwant:\n%s", b.String(), want)
+	}
+
+	prev := &Summary{Time: start, Repos: map[string]RepoSummary{"org/a": {}}}
+	cur := &Summary{Time: testNow, Repos: map[string]RepoSummary{"org/a": {
+		Contributors: []string{"alice", "bob", "carol"},
+		NewContributors: []NewContributor{
+			{Login: "alice", FirstPR: start},
+			{Login: "bob", FirstPR: start},
+		},
+	}}}
+	b.Reset()
+	if err := writeDelta(&b, config, prev, cur, top, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := ",0,0,3,alice (others)\n"; !strings.HasSuffix(b.String(), want) {
+		t.Errorf("delta:\n%s\nwant a row ending with %q", b.String(), want)
+	}
+	b.Reset()
+	if err := writeNewContributors(&b, config, cur, top, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Repo,Login,First PR\norg/a,alice,2024-03-04\norg/a,(others),2024-03-04\n"; b.String() != want {
+		t.Errorf("new contributors:\n%s\nwant:\n%s", b.String(), want)
+	}
+
+	config.Settings.SQLite = "reposcan.db"
+	b.Reset()
+	if err := writeSQLite(&b, config, repos, users, top, nil); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), "reposcan.db")
+	if err := os.WriteFile(name, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var prs int
+	err = db.QueryRow("SELECT prs FROM contributors WHERE login = ?", othersLogin).Scan(&prs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prs != 4 {
+		t.Errorf("%d prs of the aggregated contributors, want 4", prs)
+	}
+}
+
+// inTempDir runs the test in a temporary directory, with an empty
+// manifest.
+func inTempDir(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	saved := manifest
+	manifest = nil
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		manifest = saved
+	})
+}
+
+func TestSaveSummaryManifest(t *testing.T) {
+	inTempDir(t)
+	s := &Summary{Time: testNow, Repos: map[string]RepoSummary{"org/a": {}}}
+	if err := saveSummary(summaryFile, "summary", s, nil); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(summaryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ManifestEntry{{Path: summaryFile, Size: info.Size(), Type: "summary"}}
+	if !reflect.DeepEqual(manifest, want) {
+		t.Errorf("manifest %v, want %v", manifest, want)
+	}
+	loaded, err := loadSummary(summaryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Time.Equal(testNow) || len(loaded.Repos) != 1 {
+		t.Errorf("loaded summary %+v", loaded)
+	}
+}
+
+func TestSaveManifestNoFiles(t *testing.T) {
+	inTempDir(t)
+	saved := *noFiles
+	*noFiles = true
+	defer func() { *noFiles = saved }()
+
+	if err := saveManifest(manifestFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(manifestFile); !os.IsNotExist(err) {
+		t.Errorf("manifest written with no files: %v", err)
+	}
+
+	*noFiles = false
+	if err := saveManifest(manifestFile); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(manifestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "[]" {
+		t.Errorf("manifest %q, want an empty list", data)
+	}
+}
+
+func TestProrateCounts(t *testing.T) {
+	start := testNow.Add(-7 * 12 * time.Hour)
+	p := Pulse{
+		Start:        start,
+		End:          start.AddDate(0, 0, 7),
+		Days:         7,
+		PrMerged:     4,
+		Reverts:      1,
+		IssuesClosed: 3,
+		MergedSmall:  2,
+		MergedMedium: 1,
+		MergedLarge:  1,
+		MegaPRs:      1,
+		ShortBodies:  1,
+	}
+	prorate(&p, testNow)
+
+	// Half the pulse has elapsed
+	want := []float32{8, 2, 6, 4, 2, 2, 2, 2}
+	got := []float32{p.PrMerged, p.Reverts, p.IssuesClosed, p.MergedSmall, p.MergedMedium, p.MergedLarge, p.MegaPRs, p.ShortBodies}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("prorated %v, want %v", got, want)
+	}
+}
+
+func TestPRGraphUnapprovedColumn(t *testing.T) {
+	setTestNow(t)
+	pulls := testPulls(100, 4)
+	for _, approvals := range []int{0, 1} {
+		config := testConfig()
+		config.Settings.PR.Approvals = approvals
+		users := getUsers(config, pulls, nil)
+		pulses := getPulses(config, testNow.AddDate(0, -1, 0), testNow, pulls, users)
+
+		var b bytes.Buffer
+		if err := writePRGraph(&b, config, nil, "org", "repo", pulses); err != nil {
+			t.Fatal(err)
+		}
+		rows := strings.Split(strings.TrimSpace(b.String()), "\n")
+		header := strings.Split(rows[1], ",")
+		if got := strings.Contains(rows[1], "Merged (Unapproved)"); got != (approvals > 0) {
+			t.Errorf("approvals %d: unapproved column %v", approvals, got)
+		}
+		for _, row := range rows[2:] {
+			if n := len(strings.Split(row, ",")); n != len(header) {
+				t.Errorf("approvals %d: row of %d fields, header of %d", approvals, n, len(header))
+			}
+		}
+	}
+}
+
+func TestLinesCustomMetric(t *testing.T) {
+	setTestNow(t)
+	config := testConfig()
+	config.Settings.CustomMetrics = map[string]string{"churn": "lines_added + lines_deleted"}
+	start := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	pull := func(number int, state string, additions int, deletions int) PrEntry {
+		var p PrEntry
+		p.Number = number
+		p.Repository.NameWithOwner = "org/repo"
+		p.Author.Login = "author"
+		p.State = state
+		p.Additions = additions
+		p.Deletions = deletions
+		p.CreatedAt = start.Add(24 * time.Hour)
+		p.UpdatedAt = start.Add(48 * time.Hour)
+		if state == "MERGED" {
+			p.ClosedAt = &p.UpdatedAt
+			p.MergedAt = &p.UpdatedAt
+		}
+		return p
+	}
+	// Only the lines of merged PRs are counted
+	pulls := []PrEntry{
+		pull(1, "MERGED", 10, 5),
+		pull(2, "MERGED", 20, 0),
+		pull(3, "OPEN", 100, 100),
+	}
+	users := getUsers(config, pulls, nil)
+	pulses := getPulses(config, start, start.AddDate(0, 0, 6), pulls, users)
+	if len(pulses) != 1 {
+		t.Fatalf("%d pulses, want 1", len(pulses))
+	}
+	if pulses[0].LinesAdded != 30 || pulses[0].LinesDeleted != 5 {
+		t.Errorf("lines added %v, deleted %v, want 30 and 5", pulses[0].LinesAdded, pulses[0].LinesDeleted)
+	}
+
+	custom, err := customMetrics(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if churn := custom[0].Expr.Eval(pulseMetricValues(pulses[0])); churn != 35 {
+		t.Errorf("churn %v, want 35", churn)
+	}
+}
+
+func TestSaveSummaryAnonymised(t *testing.T) {
+	inTempDir(t)
+	anon, err := newAnonymiser("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := map[string]User{
+		"alice": {Start: testNow.AddDate(0, 0, -3)},
+		"bob":   {Start: testNow.AddDate(0, 0, -2)},
+	}
+	anon.Assign(users)
+
+	s := &Summary{Time: testNow, Repos: map[string]RepoSummary{"org/a": {}}}
+	r := s.Repos["org/a"]
+	r.setContributors(testConfig(), users)
+	r.setNewContributors(testConfig(), users, []Pulse{{Start: testNow.AddDate(0, 0, -7), End: testNow}})
+	s.Repos["org/a"] = r
+	if err := saveSummary(summaryFile, "summary", s, anon); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(summaryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for login := range users {
+		if strings.Contains(string(data), login) {
+			t.Errorf("summary contains the login %q:\n%s", login, data)
+		}
+	}
+
+	// The delta compares the pseudonyms of the saved summary
+	prev, err := loadSummary(summaryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(prev.Repos["org/a"].Contributors); n != 2 {
+		t.Fatalf("%d contributors saved, want 2", n)
+	}
+	if n := len(prev.Repos["org/a"].NewContributors); n != 2 {
+		t.Fatalf("%d new contributors saved, want 2", n)
+	}
+	config := testConfig()
+	config.Repos = []string{"org/a"}
+	var b bytes.Buffer
+	if err := writeDelta(&b, config, prev, s, nil, anon); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), ",0,0,0,\n") {
+		t.Errorf("delta against the anonymised summary:\n%s", b.String())
+	}
+}
+
+func TestThresholdsSkipPartial(t *testing.T) {
+	config := testConfig()
+	max := 10.0
+	config.Thresholds = map[string]Threshold{"merged": {Max: &max}}
+	start := time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)
+	pulses := []Pulse{
+		{Start: start, End: start.AddDate(0, 0, 7), Days: 7, PrMerged: 4},
+		// A single PR prorated from the first day of the pulse
+		{Start: start.AddDate(0, 0, 7), End: start.AddDate(0, 0, 14), Days: 7, PrMerged: 14, Partial: true},
+	}
+	breaches, err := checkThresholds(config, "org/a", pulses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(breaches) != 0 {
+		t.Errorf("breaches of the partial pulse: %v", breaches)
+	}
+
+	pulses[0].PrMerged = 12
+	breaches, err = checkThresholds(config, "org/a", pulses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(breaches) != 1 || !strings.Contains(breaches[0], pulseDate(config, start)) {
+		t.Errorf("breaches %v, want one in the pulse of %s", breaches, pulseDate(config, start))
+	}
+}
+
+// testReviewedPR returns a PR reviewed by each of the reviewers two hours
+// after their review was requested, an hour after start.
+func testReviewedPR(start time.Time, reviewers ...string) PrEntry {
+	var p PrEntry
+	for _, reviewer := range reviewers {
+		requested := start.Add(time.Hour)
+		reviewed := start.Add(3 * time.Hour)
+		var n, r struct {
+			ReviewRequested struct {
+				CreatedAt         time.Time
+				RequestedReviewer struct {
+					User struct {
+						Login string
+					} `graphql:"... on User"`
+				}
+			} `graphql:"... on ReviewRequestedEvent"`
+			Review struct {
+				Author struct {
+					Login string
+				}
+				SubmittedAt *time.Time
+			} `graphql:"... on PullRequestReview"`
+		}
+		n.ReviewRequested.CreatedAt = requested
+		n.ReviewRequested.RequestedReviewer.User.Login = reviewer
+		r.Review.Author.Login = reviewer
+		r.Review.SubmittedAt = &reviewed
+		p.Timeline.Nodes = append(p.Timeline.Nodes, n, r)
+	}
+	return p
+}
+
+func TestReviewerTurnaroundRepoAllowlist(t *testing.T) {
+	config := testConfig()
+	config.Repos = []string{"org/a", "org/b"}
+	config.Settings.Contributors.RepoAllowlists = map[string][]string{
+		"org/a": {"alice"},
+		"org/b": {"bob"},
+	}
+	start := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+
+	// A PR reviewed by both reviewers, in each repo
+	p := testReviewedPR(start, "alice", "bob")
+	repos := map[string]*Repo{
+		"org/a": {prs: []PrEntry{p}},
+		"org/b": {prs: []PrEntry{p}},
+	}
+
+	var b bytes.Buffer
+	if err := writeReviewerTurnaround(&b, config, repos, start, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := "Login,Reviews,Turnaround Hours (Median)\nalice,1,2.00\nbob,1,2.00\n"
+	if b.String() != want {
+		t.Errorf("turnaround:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestNoFilesEvents(t *testing.T) {
+	inTempDir(t)
+	savedNoFiles, savedEvents := *noFiles, *eventsFile
+	*noFiles, *eventsFile = true, "events.jsonl"
+	defer func() { *noFiles, *eventsFile = savedNoFiles, savedEvents }()
+
+	hub := newEventHub()
+	var path string
+	captureStdout(t, func() { path = eventsFilePath() })
+	w, err := newEventWriter(path, hub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(testConfig(), "org/a", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat("events.jsonl"); !os.IsNotExist(err) {
+		t.Errorf("events file written with no files: %v", err)
+	}
+	if len(hub.events) != 1 {
+		t.Errorf("%d events published, want 1", len(hub.events))
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/shurcooL/githubv4"
+)
+
+const defaultAPIURL = "https://api.github.com"
+
+// apiURL returns the base URL of the REST API, as configured by
+// Fetch.APIURL, or the public Github API by default.
+func apiURL(config Config) string {
+	if u := config.Settings.Fetch.APIURL; u != "" {
+		return strings.TrimSuffix(u, "/")
+	}
+	return defaultAPIURL
+}
+
+// graphqlURL returns the URL of the GraphQL API, derived from the REST
+// API base URL. Github Enterprise Server serves the REST API at /api/v3
+// and the GraphQL API at /api/graphql, while the public Github API
+// serves the GraphQL API at /graphql.
+func graphqlURL(config Config) string {
+	return strings.TrimSuffix(apiURL(config), "/v3") + "/graphql"
+}
+
+// newGraphQLClient returns a GraphQL client of the configured API.
+func newGraphQLClient(config Config, httpClient *http.Client) *githubv4.Client {
+	return githubv4.NewEnterpriseClient(graphqlURL(config), httpClient)
+}
+
+// restSource reads PRs using the Github REST API, for Github instances
+// where the GraphQL API is unavailable. The REST list endpoints do not
+// report the PR size, reviews or merge queue events, so these are left
+// empty.
+type restSource struct {
+	client  *http.Client
+	baseURL string
+}
+
+type restRepo struct {
+	CreatedAt     time.Time `json:"created_at"`
+	DefaultBranch string    `json:"default_branch"`
+	Owner         struct {
+		Type string `json:"type"`
+	} `json:"owner"`
+}
+
+type restUser struct {
+	Login string `json:"login"`
+}
+
+type restPull struct {
+	NodeID    string      `json:"node_id"`
+	Number    int         `json:"number"`
+	State     string      `json:"state"`
+	Title     string      `json:"title"`
+	Body      string      `json:"body"`
+	User      restUser    `json:"user"`
+	Assoc     string      `json:"author_association"`
+	Assignees []restUser  `json:"assignees"`
+	Labels    []restLabel `json:"labels"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+	ClosedAt  *time.Time  `json:"closed_at"`
+	MergedAt  *time.Time  `json:"merged_at"`
+	Base      struct {
+		Ref  string `json:"ref"`
+		Repo struct {
+			FullName string `json:"full_name"`
+		} `json:"repo"`
+	} `json:"base"`
+}
+
+type restIssue struct {
+	NodeID        string      `json:"node_id"`
+	Number        int         `json:"number"`
+	State         string      `json:"state"`
+	Title         string      `json:"title"`
+	Body          string      `json:"body"`
+	User          restUser    `json:"user"`
+	Assoc         string      `json:"author_association"`
+	Assignees     []restUser  `json:"assignees"`
+	Labels        []restLabel `json:"labels"`
+	CreatedAt     time.Time   `json:"created_at"`
+	UpdatedAt     time.Time   `json:"updated_at"`
+	ClosedAt      *time.Time  `json:"closed_at"`
+	RepositoryURL string      `json:"repository_url"`
+	PullRequest   *struct {
+		MergedAt *time.Time `json:"merged_at"`
+	} `json:"pull_request"`
+}
+
+type restSearch struct {
+	TotalCount int         `json:"total_count"`
+	Items      []restIssue `json:"items"`
+}
+
+// restAssignees sets the first assignee of e, as only the first is
+// requested from the GraphQL API.
+func restAssignees(e *PrEntry, assignees []restUser) {
+	if len(assignees) > 0 {
+		e.Assignees.Nodes = append(e.Assignees.Nodes, struct{ Login string }{assignees[0].Login})
+	}
+}
+
+type restLabel struct {
+	Name string `json:"name"`
+}
+
+// restLabels sets the labels of e.
+func restLabels(e *PrEntry, labels []restLabel) {
+	for _, l := range labels {
+		e.Labels.Nodes = append(e.Labels.Nodes, struct{ Name string }{l.Name})
+	}
+}
+
+// restState returns the GraphQL PR state matching a REST PR state, as
+// REST reports merged PRs as closed.
+func restState(state string, mergedAt *time.Time) string {
+	if mergedAt != nil {
+		return "MERGED"
+	}
+	return strings.ToUpper(state)
+}
+
+func (p restPull) entry() PrEntry {
+	var e PrEntry
+	e.ID = p.NodeID
+	e.Number = p.Number
+	e.State = restState(p.State, p.MergedAt)
+	e.Title = p.Title
+	e.Body = p.Body
+	e.CreatedAt = p.CreatedAt
+	e.UpdatedAt = p.UpdatedAt
+	e.ClosedAt = p.ClosedAt
+	e.MergedAt = p.MergedAt
+	e.BaseRefName = p.Base.Ref
+	e.Author.Login = p.User.Login
+	e.AuthorAssociation = p.Assoc
+	restAssignees(&e, p.Assignees)
+	restLabels(&e, p.Labels)
+	e.Repository.NameWithOwner = p.Base.Repo.FullName
+	return e
+}
+
+func (i restIssue) entry() PrEntry {
+	var e PrEntry
+	e.ID = i.NodeID
+	e.Number = i.Number
+	e.State = restState(i.State, i.PullRequest.MergedAt)
+	e.Title = i.Title
+	e.Body = i.Body
+	e.CreatedAt = i.CreatedAt
+	e.UpdatedAt = i.UpdatedAt
+	e.ClosedAt = i.ClosedAt
+	e.MergedAt = i.PullRequest.MergedAt
+	e.Author.Login = i.User.Login
+	e.AuthorAssociation = i.Assoc
+	restAssignees(&e, i.Assignees)
+	restLabels(&e, i.Labels)
+	if n := strings.Index(i.RepositoryURL, "/repos/"); n >= 0 {
+		e.Repository.NameWithOwner = i.RepositoryURL[n+len("/repos/"):]
+	}
+	return e
+}
+
+var restNextLink = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// get requests a REST resource into v, and returns the URL of the next
+// page, if any.
+func (r *restSource) get(ctx context.Context, u string, v interface{}) (next string, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return "", fmt.Errorf("%s: cannot decode response: %w", u, err)
+	}
+	if m := restNextLink.FindStringSubmatch(resp.Header.Get("Link")); m != nil {
+		next = m[1]
+	}
+	return next, nil
+}
+
+func (r *restSource) RepoPulls(ctx context.Context, config Config, org string, repo string, stats *FetchStats) (start time.Time, prs []PrEntry, seed []PrEntry, err error) {
+	begin := time.Now()
+	defer func() {
+		stats.Duration += time.Since(begin)
+	}()
+
+	var info restRepo
+	_, err = r.get(ctx, fmt.Sprintf("%s/repos/%s/%s", r.baseURL, org, repo), &info)
+	if err != nil {
+		return start, prs, seed, fmt.Errorf("repo request failed: %w", err)
+	}
+	stats.Cost += 1
+
+	for attempt := 0; ; attempt++ {
+		var read, total int
+		prs, seed, read, total, err = r.repoPullsScan(ctx, config, org, repo, info.DefaultBranch, stats)
+		if err != nil {
+			return start, prs, seed, err
+		}
+
+		// A mismatch means a page was dropped or the repo changed
+		// while we were paginating through it.
+		if read == total {
+			break
+		}
+		fmt.Printf("%s/%s: warning: read %d prs but expected %d\n", org, repo, read, total)
+
+		if attempt >= config.Settings.Fetch.Rescan {
+			break
+		}
+		fmt.Printf("%s/%s: re-scanning pr history (%d/%d)...\n", org, repo, attempt+1, config.Settings.Fetch.Rescan)
+	}
+	fmt.Printf("\r%s/%s: %d prs against branch %s...\n", org, repo, len(prs), info.DefaultBranch)
+
+	return info.CreatedAt, prs, seed, nil
+}
+
+// repoPullsScan paginates through the PR history of a repo against the
+// default branch. It returns the PRs and the seed PRs, along with the
+// number of PRs read within the fetched range and the number expected.
+func (r *restSource) repoPullsScan(ctx context.Context, config Config, org string, repo string, branch string, stats *FetchStats) (prs []PrEntry, seed []PrEntry, read int, total int, err error) {
+	// Only the PRs against the default branch are requested, unless
+	// it has former names to include as well
+	q := url.Values{}
+	q.Set("state", "all")
+	aliased := hasBranchAlias(config, branch)
+	if !aliased {
+		q.Set("base", branch)
+	}
+	q.Set("per_page", "100")
+	cutoff, cut := fetchCutoff(config)
+	if cut {
+		q.Set("sort", "updated")
+		q.Set("direction", "desc")
+	}
+	total, err = r.pullCount(ctx, config, org, repo, branch, stats)
+	if err != nil {
+		return prs, seed, read, total, err
+	}
+
+	// The PRs before the cutoff are read on to the seed cutoff, for the
+	// activity of the contributors only
+	last := cutoff
+	if seedTime, ok := seedCutoff(config); ok {
+		last = seedTime
+	}
+	next := fmt.Sprintf("%s/repos/%s/%s/pulls?%s", r.baseURL, org, repo, q.Encode())
+	for next != "" {
+		var page []restPull
+		next, err = r.get(ctx, next, &page)
+		if err != nil {
+			return prs, seed, read, total, fmt.Errorf("repo requests failed: %w", err)
+		}
+
+		stats.Pages += 1
+		stats.Nodes += len(page)
+		stats.Cost += 1
+
+		for _, p := range page {
+			e := p.entry()
+			if !includedState(config, e.State) {
+				continue
+			}
+			onBranch := !aliased || defaultBranchPR(config, e.BaseRefName, branch)
+			if cut && e.UpdatedAt.Before(cutoff) {
+				if onBranch {
+					seed = append(seed, e)
+				}
+				continue
+			}
+			// The expected count spans all branches if aliased
+			read += 1
+			if onBranch {
+				prs = append(prs, e)
+			}
+		}
+
+		if next != "" && !*quiet {
+			fmt.Printf("\r%s/%s: reading pr history (%d/%d)...", org, repo, read, total)
+		}
+
+		// The remaining PRs were all last updated before the cutoff
+		if cut && len(page) > 0 && page[len(page)-1].UpdatedAt.Before(last) {
+			break
+		}
+	}
+
+	if !*quiet {
+		fmt.Printf("\r%s/%s: reading pr history (%d/%d)...              \n", org, repo, read, total)
+	}
+
+	return prs, seed, read, total, nil
+}
+
+// pullCount returns the number of PRs of a repo in the included states,
+// from the total count of the Github search. Without branch aliases
+// only the PRs against the branch are counted, as only those are
+// listed, and with a cutoff only the PRs updated since.
+func (r *restSource) pullCount(ctx context.Context, config Config, org string, repo string, branch string, stats *FetchStats) (int, error) {
+	qualifiers, err := stateQualifiers(config)
+	if err != nil {
+		return 0, err
+	}
+	query := fmt.Sprintf("repo:%s/%s is:pr", org, repo)
+	if !hasBranchAlias(config, branch) {
+		query += " base:" + branch
+	}
+	if cutoff, cut := fetchCutoff(config); cut {
+		query += " updated:>=" + cutoff.UTC().Format(time.RFC3339)
+	}
+
+	total := 0
+	for _, qualifier := range qualifiers {
+		q := url.Values{}
+		q.Set("q", query+qualifier)
+		q.Set("per_page", "1")
+		var page restSearch
+		_, err := r.get(ctx, fmt.Sprintf("%s/search/issues?%s", r.baseURL, q.Encode()), &page)
+		if err != nil {
+			return 0, fmt.Errorf("pr count request failed: %w", err)
+		}
+		stats.Cost += 1
+		total += page.TotalCount
+	}
+	return total, nil
+}
+
+func (r *restSource) SearchPulls(ctx context.Context, config Config, s Search, stats *FetchStats) (start time.Time, prs []PrEntry, err error) {
+	begin := time.Now()
+	defer func() {
+		stats.Duration += time.Since(begin)
+	}()
+
+	q := url.Values{}
+	q.Set("q", s.Query)
+	q.Set("per_page", "100")
+	next := fmt.Sprintf("%s/search/issues?%s", r.baseURL, q.Encode())
+	start = now
+	for next != "" {
+		var page restSearch
+		next, err = r.get(ctx, next, &page)
+		if err != nil {
+			return start, prs, fmt.Errorf("search requests failed: %w", err)
+		}
+
+		stats.Pages += 1
+		stats.Nodes += len(page.Items)
+		stats.Cost += 1
+
+		for _, v := range page.Items {
+			// Issues matching the query are not PRs
+			if v.PullRequest == nil {
+				continue
+			}
+			prs = append(prs, v.entry())
+			if start.After(v.CreatedAt) {
+				start = v.CreatedAt
+			}
+		}
+
+		if !*quiet {
+			fmt.Printf("\r%s: reading search results (%d/%d)...", searchKey(s), len(prs), page.TotalCount)
+		}
+	}
+
+	fmt.Printf("\r%s: %d prs matching %q...\n", searchKey(s), len(prs), s.Query)
+
+	return start, prs, nil
+}
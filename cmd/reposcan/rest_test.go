@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRestRepoPullsRescan(t *testing.T) {
+	pulls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/org/a":
+			fmt.Fprint(w, `{"created_at": "2024-01-01T00:00:00Z", "default_branch": "main"}`)
+		case "/repos/org/a/pulls":
+			// A PR is dropped from the first listing
+			pulls += 1
+			prs := []string{
+				`{"number": 1, "state": "open", "created_at": "2024-01-02T00:00:00Z", "base": {"ref": "main"}}`,
+				`{"number": 2, "state": "closed", "created_at": "2024-01-03T00:00:00Z", "base": {"ref": "main"}}`,
+			}
+			if pulls > 1 {
+				prs = append(prs, `{"number": 3, "state": "open", "created_at": "2024-01-04T00:00:00Z", "base": {"ref": "main"}}`)
+			}
+			fmt.Fprintf(w, "[%s]", strings.Join(prs, ","))
+		case "/search/issues":
+			if q := r.URL.Query().Get("q"); q != "repo:org/a is:pr base:main" {
+				t.Errorf("count query %q", q)
+			}
+			fmt.Fprint(w, `{"total_count": 3, "items": []}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	config := testConfig()
+	config.Settings.Fetch.Rescan = 1
+	r := &restSource{client: srv.Client(), baseURL: srv.URL}
+	var stats FetchStats
+	var prs []PrEntry
+	var err error
+	out := captureStdout(t, func() {
+		_, prs, _, err = r.RepoPulls(context.Background(), config, "org", "a", &stats)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prs) != 3 {
+		t.Errorf("%d prs, want 3", len(prs))
+	}
+	if pulls != 2 {
+		t.Errorf("pulls listed %d times, want 2", pulls)
+	}
+	for _, want := range []string{
+		"org/a: warning: read 2 prs but expected 3\n",
+		"org/a: re-scanning pr history (1/1)...\n",
+		"org/a: 3 prs against branch main...\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output %q does not contain %q", out, want)
+		}
+	}
+
+	// Without progress output, only the result is printed
+	*quiet = true
+	defer func() { *quiet = false }()
+	pulls = 1
+	out = captureStdout(t, func() {
+		_, _, _, err = r.RepoPulls(context.Background(), config, "org", "a", &stats)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "\rorg/a: 3 prs against branch main...\n" {
+		t.Errorf("quiet output %q", out)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// retryAfterLimit is the maximum number of times a request is retried
+// after a secondary rate limit response.
+const retryAfterLimit = 5
+
+// retryAfterTransport retries requests rejected by the Github secondary
+// (abuse detection) rate limits. These responses carry a Retry-After
+// header with the number of seconds to wait before retrying, and are
+// unrelated to the rate limit points reported by the API.
+type retryAfterTransport struct {
+	base http.RoundTripper
+}
+
+func (t *retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if err != nil {
+			return resp, err
+		}
+		if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+			return resp, nil
+		}
+		secs, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		if err != nil || attempt >= retryAfterLimit {
+			return resp, nil
+		}
+		// The request body was consumed, and can only be sent
+		// again if it can be recreated.
+		if req.Body != nil && req.GetBody == nil {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		wait := time.Duration(secs) * time.Second
+		fmt.Printf("\nwarning: secondary rate limit hit, retrying in %s (%d/%d)...\n", wait, attempt+1, retryAfterLimit)
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// streamHub receives the repo events of the scan in the serve mode. It
+// is nil otherwise.
+var streamHub *EventHub
+
+// DoneEvent is the last event of a stream, sent once the scan ended.
+type DoneEvent struct {
+	Error string `json:"error,omitempty"`
+}
+
+// sseEvent is a named server-sent event.
+type sseEvent struct {
+	name string
+	data []byte
+}
+
+// EventHub keeps the events of a scan and sends them to the clients of
+// the stream as server-sent events. Every client is first sent the
+// events published before it connected, so clients connecting late do
+// not miss any repos.
+type EventHub struct {
+	mu     sync.Mutex
+	events []sseEvent
+	done   bool
+	// Closed and replaced whenever an event is published
+	changed chan struct{}
+}
+
+func newEventHub() *EventHub {
+	return &EventHub{changed: make(chan struct{})}
+}
+
+// Publish sends an event to all clients. No events are published after
+// the done event.
+func (h *EventHub) Publish(name string, data []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.done {
+		return
+	}
+	h.events = append(h.events, sseEvent{name: name, data: data})
+	h.done = (name == "done")
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// Done publishes the done event, with the error of the scan if it
+// failed.
+func (h *EventHub) Done(err error) {
+	var e DoneEvent
+	if err != nil {
+		e.Error = err.Error()
+	}
+	data, _ := json.Marshal(e)
+	h.Publish("done", data)
+}
+
+// ServeHTTP streams the events to a client until the done event was
+// sent, or the client disconnects.
+func (h *EventHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	sent := 0
+	for {
+		h.mu.Lock()
+		pending := h.events[sent:]
+		done := h.done
+		changed := h.changed
+		h.mu.Unlock()
+
+		for _, e := range pending {
+			_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.name, e.data)
+			if err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+		sent += len(pending)
+		if done {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// runServe runs a scan in the background, serving its repo events on
+// /stream at addr as they are computed. The server keeps running after
+// the scan, so clients can still read the events, until interrupted.
+func runServe(addr string) error {
+	streamHub = newEventHub()
+	mux := http.NewServeMux()
+	mux.Handle("/stream", streamHub)
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		fmt.Println("Error listening:", err)
+		return err
+	}
+	srv := &http.Server{Handler: mux}
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(ln)
+	}()
+	fmt.Printf("serving the scan events on http://%s/stream...\n", ln.Addr())
+
+	scanErr := make(chan error, 1)
+	go func() {
+		err := run()
+		streamHub.Done(err)
+		scanErr <- err
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var result error
+	select {
+	case result = <-scanErr:
+		fmt.Printf("scan done, serving the events until interrupted...\n")
+		<-ctx.Done()
+	case <-ctx.Done():
+		result = errors.New("interrupted during the scan")
+		fmt.Println("Error scanning:", result)
+	case err := <-served:
+		fmt.Println("Error serving:", err)
+		return err
+	}
+
+	// Ends the streams still waiting for events
+	streamHub.Done(result)
+	fmt.Printf("stopping the server...\n")
+	err = srv.Shutdown(context.Background())
+	if err != nil {
+		fmt.Println("Error stopping the server:", err)
+		return err
+	}
+	return result
+}
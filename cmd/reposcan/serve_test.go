@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readStream returns the events of a stream as "name data" lines, until
+// the stream ends.
+func readStream(t *testing.T, url string) []string {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("content type %q, want text/event-stream", ct)
+	}
+
+	var events []string
+	var name string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			events = append(events, name+" "+strings.TrimPrefix(line, "data: "))
+		}
+	}
+	return events
+}
+
+func TestEventHubStream(t *testing.T) {
+	hub := newEventHub()
+	srv := httptest.NewServer(hub)
+	defer srv.Close()
+
+	hub.Publish("repo", []byte(`{"repo":"org/a"}`))
+
+	// A client connected during the scan gets the earlier events, and
+	// the later ones as they are published
+	streamed := make(chan []string)
+	go func() {
+		streamed <- readStream(t, srv.URL)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	hub.Publish("repo", []byte(`{"repo":"org/b"}`))
+	hub.Done(errors.New("failed"))
+
+	want := []string{
+		`repo {"repo":"org/a"}`,
+		`repo {"repo":"org/b"}`,
+		`done {"error":"failed"}`,
+	}
+	select {
+	case got := <-streamed:
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("streamed events:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream did not end after the done event")
+	}
+
+	// A client connected after the scan gets all the events, and no
+	// events are published after the done event
+	hub.Publish("repo", []byte(`{"repo":"org/c"}`))
+	if got := readStream(t, srv.URL); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("replayed events:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
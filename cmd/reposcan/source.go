@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/shurcooL/githubv4"
+)
+
+// Source reads the PR history of repos, and the PRs matching searches,
+// from a Github API.
+type Source interface {
+	// RepoPulls returns the creation time of a repo, its PRs against
+	// the default branch, and the older PRs read only to seed the
+	// contributors.
+	RepoPulls(ctx context.Context, config Config, org string, repo string, stats *FetchStats) (time.Time, []PrEntry, []PrEntry, error)
+	// SearchPulls returns the creation time of the oldest PR found,
+	// and the PRs matching the search.
+	SearchPulls(ctx context.Context, config Config, s Search, stats *FetchStats) (time.Time, []PrEntry, error)
+}
+
+// newSource returns the source selected by the api mode setting,
+// sending its requests with the authenticated HTTP client.
+func newSource(config Config, httpClient *http.Client) (Source, error) {
+	switch config.Settings.Fetch.APIMode {
+	case "", "graphql":
+		return &graphqlSource{
+			client: newGraphQLClient(config, httpClient),
+			first:  make(map[string]*batchPage),
+		}, nil
+	case "rest":
+		return &restSource{client: httpClient, baseURL: apiURL(config)}, nil
+	}
+	return nil, fmt.Errorf("unknown api mode %q", config.Settings.Fetch.APIMode)
+}
+
+// graphqlSource reads PRs using the Github GraphQL API.
+type graphqlSource struct {
+	client *githubv4.Client
+	// First pages of the repos requested in a batch, and not yet
+	// read, by repo key
+	first map[string]*batchPage
+	// Set once a batch failed, after which repos are read one by one
+	unbatched bool
+}
+
+func (g *graphqlSource) RepoPulls(ctx context.Context, config Config, org string, repo string, stats *FetchStats) (time.Time, []PrEntry, []PrEntry, error) {
+	first := g.firstPage(ctx, config, org+"/"+repo, stats)
+	return repoPulls(ctx, g.client, config, org, repo, first, stats)
+}
+
+// firstPage returns the first page of the PR history of a repo, if
+// batching is enabled. Unless already requested, the first pages of the
+// repo and the configured repos following it are requested in a single
+// batch. If the batch fails (for example because one of the repos is
+// not accessible), nil is returned, and from then on the repos are read
+// one by one. Repos missing from a partial batch response are read one
+// by one as well.
+func (g *graphqlSource) firstPage(ctx context.Context, config Config, key string, stats *FetchStats) *RepoEntry {
+	size := config.Settings.Fetch.BatchSize
+	if size <= 1 || g.unbatched {
+		return nil
+	}
+	if page, ok := g.first[key]; ok {
+		delete(g.first, key)
+		stats.add(page.stats)
+		return page.entry
+	}
+
+	keys := []string{key}
+	found := false
+	for _, k := range config.Repos {
+		if len(keys) == size {
+			break
+		}
+		if k == key {
+			found = true
+			continue
+		}
+		if _, ok := g.first[k]; found && !ok {
+			keys = append(keys, k)
+		}
+	}
+
+	pages, err := repoFirstPages(ctx, g.client, config, keys, stats)
+	if err != nil {
+		fmt.Printf("%s: warning: %v, reading repos one by one\n", key, err)
+		g.unbatched = true
+		return nil
+	}
+	for _, k := range keys[1:] {
+		if page, ok := pages[k]; ok {
+			g.first[k] = page
+		}
+	}
+	page, ok := pages[key]
+	if !ok {
+		return nil
+	}
+	stats.add(page.stats)
+	return page.entry
+}
+
+func (g *graphqlSource) SearchPulls(ctx context.Context, config Config, s Search, stats *FetchStats) (time.Time, []PrEntry, error) {
+	return searchPulls(ctx, g.client, config, s, stats)
+}
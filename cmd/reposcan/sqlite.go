@@ -0,0 +1,206 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// PrRow is the row of a PR in the prs table of the SQLite database. In
+// streaming mode only the rows are kept of the PRs, and only if the
+// database is written.
+type PrRow struct {
+	ID        string
+	Author    string
+	State     string
+	Title     string
+	Created   time.Time
+	Merged    *time.Time
+	Closed    *time.Time
+	Additions int
+	Deletions int
+	Files     int
+}
+
+func newPrRow(p PrEntry) PrRow {
+	return PrRow{
+		ID:        p.ID,
+		Author:    p.Author.Login,
+		State:     p.State,
+		Title:     p.Title,
+		Created:   p.CreatedAt,
+		Merged:    p.MergedAt,
+		Closed:    p.ClosedAt,
+		Additions: p.Additions,
+		Deletions: p.Deletions,
+		Files:     p.ChangedFiles,
+	}
+}
+
+// prRows returns the rows of the PRs of a repo.
+func prRows(r *Repo) []PrRow {
+	if r.stream {
+		return r.rows
+	}
+	rows := make([]PrRow, 0, len(r.prs))
+	for _, p := range r.prs {
+		rows = append(rows, newPrRow(p))
+	}
+	return rows
+}
+
+// sqlTime returns t as an SQLite time value, or nil for NULL if t is nil.
+func sqlTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func genSQLite(config Config, repos map[string]*Repo, users map[string]User, top map[string]bool, anon *Anonymiser) error {
+	return writeFile(config.Settings.SQLite, "sqlite", func(out io.Writer) error {
+		return writeSQLite(out, config, repos, users, top, anon)
+	})
+}
+
+// writeSQLite writes an SQLite database to out, with the pulses,
+// contributors and prs tables. The pulses table has a column per
+// built-in pulse metric. The contributors outside the top contributors
+// share a contributors row, spanning all their activity, and are the
+// aggregated bucket as PR authors. The database is built in a temporary
+// file, which is copied to out.
+func writeSQLite(out io.Writer, config Config, repos map[string]*Repo, users map[string]User, top map[string]bool, anon *Anonymiser) error {
+	f, err := os.CreateTemp("", "reposcan-*.db")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	defer os.Remove(name)
+
+	db, err := sql.Open("sqlite", name)
+	if err != nil {
+		return err
+	}
+	err = fillSQLite(db, config, repos, users, top, anon)
+	if cerr := db.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	f, err = os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(out, f)
+	return err
+}
+
+// fillSQLite creates and fills the tables of db in a single transaction.
+func fillSQLite(db *sql.DB, config Config, repos map[string]*Repo, users map[string]User, top map[string]bool, anon *Anonymiser) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	columns := []string{"org TEXT", "repo TEXT", "start TEXT", "\"end\" TEXT"}
+	for _, m := range pulseMetrics {
+		columns = append(columns, m.Name+" REAL")
+	}
+	tables := []string{
+		fmt.Sprintf("CREATE TABLE pulses (%s)", strings.Join(columns, ", ")),
+		"CREATE TABLE contributors (login TEXT PRIMARY KEY, start TEXT, \"end\" TEXT, prs INTEGER)",
+		"CREATE TABLE prs (org TEXT, repo TEXT, id TEXT, author TEXT, state TEXT, title TEXT, created TEXT, merged TEXT, closed TEXT, additions INTEGER, deletions INTEGER, files INTEGER)",
+	}
+	for _, t := range tables {
+		if _, err := tx.Exec(t); err != nil {
+			return fmt.Errorf("cannot create table: %w", err)
+		}
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	insertPulse, err := tx.Prepare(fmt.Sprintf("INSERT INTO pulses VALUES (%s)", placeholders))
+	if err != nil {
+		return err
+	}
+	insertPR, err := tx.Prepare("INSERT INTO prs VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	insertContributor, err := tx.Prepare("INSERT INTO contributors VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+
+	for _, k := range config.Repos {
+		// Searches are keyed search/<name>, which splits the same way
+		org, repo, err := orgRepoSplit(k)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range repos[k].pulses {
+			values := []interface{}{org, repo, sqlTime(&p.Start), sqlTime(&p.End)}
+			metrics := omitMetrics(config, pulseMetricValues(p))
+			for _, m := range pulseMetrics {
+				v, ok := metrics[m.Name]
+				if !ok {
+					values = append(values, nil)
+					continue
+				}
+				values = append(values, v)
+			}
+			if _, err := insertPulse.Exec(values...); err != nil {
+				return fmt.Errorf("cannot insert pulse: %w", err)
+			}
+		}
+
+		for _, p := range prRows(repos[k]) {
+			_, err := insertPR.Exec(org, repo, p.ID, anon.Name(topLogin(top, p.Author)), p.State, p.Title,
+				sqlTime(&p.Created), sqlTime(p.Merged), sqlTime(p.Closed),
+				p.Additions, p.Deletions, p.Files)
+			if err != nil {
+				return fmt.Errorf("cannot insert pr: %w", err)
+			}
+		}
+	}
+
+	contributors := make(map[string]User)
+	for login, u := range users {
+		login = topLogin(top, login)
+		if c, ok := contributors[login]; ok {
+			if u.Start.Before(c.Start) {
+				c.Start = u.Start
+			}
+			if u.End.After(c.End) {
+				c.End = u.End
+			}
+			c.PRs += u.PRs
+			u = c
+		}
+		contributors[login] = u
+	}
+	logins := make([]string, 0, len(contributors))
+	for login := range contributors {
+		logins = append(logins, login)
+	}
+	sortOthersLast(logins)
+	for _, login := range logins {
+		u := contributors[login]
+		_, err := insertContributor.Exec(anon.Name(login), sqlTime(&u.Start), sqlTime(&u.End), u.PRs)
+		if err != nil {
+			return fmt.Errorf("cannot insert contributor: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
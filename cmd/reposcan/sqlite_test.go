@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteSQLite(t *testing.T) {
+	setTestNow(t)
+	config := testConfig()
+	config.Settings.SQLite = "reposcan.db"
+	config.Repos = []string{"org/a", "org/b"}
+	start := testNow.AddDate(0, -2, 0)
+
+	// A retained and a streamed repo
+	prs := testPulls(50, 1)
+	users := getUsers(config, prs, nil)
+	a := &Repo{prs: prs, pulses: getPulses(config, start, testNow, prs, users)}
+	acc := newPulseAccumulator(config, start, testNow)
+	for _, p := range testPulls(30, 2) {
+		acc.Add(p)
+	}
+	b := &Repo{stream: true, pulses: acc.Pulses(), rows: acc.rows}
+	repos := map[string]*Repo{"org/a": a, "org/b": b}
+
+	var out bytes.Buffer
+	if err := writeSQLite(&out, config, repos, users, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), "reposcan.db")
+	if err := os.WriteFile(name, out.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	count := func(query string, args ...interface{}) int {
+		var n int
+		if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if n := count("SELECT COUNT(*) FROM prs WHERE repo = ?", "a"); n != 50 {
+		t.Errorf("%d prs of the retained repo, want 50", n)
+	}
+	if n := count("SELECT COUNT(*) FROM prs WHERE repo = ?", "b"); n != 30 {
+		t.Errorf("%d prs of the streamed repo, want 30", n)
+	}
+	if n, want := count("SELECT COUNT(*) FROM pulses"), len(a.pulses)+len(b.pulses); n != want {
+		t.Errorf("%d pulses, want %d", n, want)
+	}
+	if n := count("SELECT COUNT(*) FROM contributors"); n != len(users) {
+		t.Errorf("%d contributors, want %d", n, len(users))
+	}
+	if n := count("SELECT COUNT(*) FROM prs WHERE state = 'MERGED' AND merged IS NULL"); n != 0 {
+		t.Errorf("%d merged prs without a merge time", n)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const summaryFile = "summary.json"
+
+// Summary is the state of all repos at the end of a run, which is saved
+// so the next run can report what changed since.
+type Summary struct {
+	Time  time.Time              `json:"time"`
+	Repos map[string]RepoSummary `json:"repos"`
+}
+
+// RepoSummary is the state of a repo at the end of a run. Only the PRs
+// of allowlisted contributors are included. Metrics holds the metrics
+// of the latest complete pulse, used when the summary is a baseline.
+type RepoSummary struct {
+	Merged          int                `json:"merged"`
+	Open            int                `json:"open"`
+	Contributors    []string           `json:"contributors"`
+	Metrics         map[string]float64 `json:"metrics,omitempty"`
+	NewContributors []NewContributor   `json:"new_contributors,omitempty"`
+}
+
+// NewContributor is a contributor whose first PR in a repo was created
+// within the pulses of the run.
+type NewContributor struct {
+	Login   string    `json:"login"`
+	FirstPR time.Time `json:"first_pr"`
+}
+
+func (s *RepoSummary) add(config Config, p PrEntry) {
+	if !allowlistedUser(config, contributorLogin(config, p)) || !includedState(config, p.State) {
+		return
+	}
+	switch {
+	case p.MergedAt != nil:
+		s.Merged += 1
+	case p.State == "OPEN":
+		s.Open += 1
+	}
+}
+
+func (s *RepoSummary) setContributors(config Config, users map[string]User) {
+	s.Contributors = make([]string, 0, len(users))
+	for login := range users {
+		if allowlistedUser(config, login) {
+			s.Contributors = append(s.Contributors, login)
+		}
+	}
+	sort.Strings(s.Contributors)
+}
+
+// setNewContributors sets the allowlisted contributors whose first PR
+// was created within the pulses, ordered by their first PR.
+func (s *RepoSummary) setNewContributors(config Config, users map[string]User, pulses []Pulse) {
+	s.NewContributors = nil
+	if len(pulses) == 0 {
+		return
+	}
+	start := pulses[0].Start
+	end := pulses[len(pulses)-1].End
+	for login, u := range users {
+		if allowlistedUser(config, login) && inWindow(u.Start, start, end) {
+			s.NewContributors = append(s.NewContributors, NewContributor{Login: login, FirstPR: u.Start})
+		}
+	}
+	sort.Slice(s.NewContributors, func(i, j int) bool {
+		a, b := s.NewContributors[i], s.NewContributors[j]
+		if !a.FirstPR.Equal(b.FirstPR) {
+			return a.FirstPR.Before(b.FirstPR)
+		}
+		return a.Login < b.Login
+	})
+}
+
+// repoSummary returns the summary of the PRs of a repo.
+func repoSummary(config Config, prs []PrEntry, users map[string]User) RepoSummary {
+	var s RepoSummary
+	for _, p := range prs {
+		s.add(config, p)
+	}
+	s.setContributors(config, users)
+	return s
+}
+
+// anonymised returns a copy of the summary with the contributor logins
+// replaced by their pseudonyms. Without an anonymiser the summary is
+// returned as is.
+func (s *Summary) anonymised(anon *Anonymiser) *Summary {
+	if anon == nil {
+		return s
+	}
+	a := &Summary{
+		Time:  s.Time,
+		Repos: make(map[string]RepoSummary, len(s.Repos)),
+	}
+	for k, r := range s.Repos {
+		contributors := make([]string, 0, len(r.Contributors))
+		for _, login := range r.Contributors {
+			contributors = append(contributors, anon.Name(login))
+		}
+		sort.Strings(contributors)
+		r.Contributors = contributors
+
+		// The new contributors stay ordered by their first PR
+		if r.NewContributors != nil {
+			newContributors := make([]NewContributor, 0, len(r.NewContributors))
+			for _, c := range r.NewContributors {
+				newContributors = append(newContributors, NewContributor{Login: anon.Name(c.Login), FirstPR: c.FirstPR})
+			}
+			r.NewContributors = newContributors
+		}
+		a.Repos[k] = r
+	}
+	return a
+}
+
+// loadSummary reads the summary saved by the previous run. If there was
+// no previous run, nil is returned.
+func loadSummary(name string) (*Summary, error) {
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s Summary
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %w", name, err)
+	}
+	return &s, nil
+}
+
+// saveSummary writes the summary to name, with the contributor logins
+// replaced by their pseudonyms when anonymising.
+func saveSummary(name string, kind string, s *Summary, anon *Anonymiser) error {
+	data, err := json.MarshalIndent(s.anonymised(anon), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(name, kind, func(out io.Writer) error {
+		_, err := out.Write(data)
+		return err
+	})
+}
+
+func genDelta(config Config, prev *Summary, cur *Summary, top map[string]bool, anon *Anonymiser) error {
+	return writeFile("delta.csv", "delta", func(out io.Writer) error {
+		return writeDelta(out, config, prev, cur, top, anon)
+	})
+}
+
+// writeDelta writes the changes of each repo since the previous run to
+// out. Repos that were not part of the previous run are left out. When
+// anonymising, the previous contributors are pseudonyms, so these are
+// compared. The new contributors outside the top contributors are
+// counted, but listed once as the aggregated bucket.
+func writeDelta(out io.Writer, config Config, prev *Summary, cur *Summary, top map[string]bool, anon *Anonymiser) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"Repo", "Since", "Merged", "Open Change", "New Contributors", "New Contributor List"})
+	for _, k := range config.Repos {
+		p, ok := prev.Repos[k]
+		if !ok {
+			fmt.Printf("%s: no previous run to compare against\n", k)
+			continue
+		}
+		c := cur.Repos[k]
+
+		known := make(map[string]bool, len(p.Contributors))
+		for _, login := range p.Contributors {
+			known[login] = true
+		}
+		count := 0
+		others := false
+		newLogins := make([]string, 0)
+		for _, login := range c.Contributors {
+			if name := anon.Name(login); !known[name] {
+				count += 1
+				if topLogin(top, login) == othersLogin {
+					others = true
+				} else {
+					newLogins = append(newLogins, name)
+				}
+			}
+		}
+		if others {
+			newLogins = append(newLogins, othersLogin)
+		}
+
+		w.Write([]string{
+			k,
+			prev.Time.Format("2006-01-02 15:04"),
+			strconv.Itoa(c.Merged - p.Merged),
+			strconv.Itoa(c.Open - p.Open),
+			strconv.Itoa(count),
+			strings.Join(newLogins, " "),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func genNewContributors(config Config, s *Summary, top map[string]bool, anon *Anonymiser) error {
+	return writeFile("new-contributors.csv", "new contributors", func(out io.Writer) error {
+		return writeNewContributors(out, config, s, top, anon)
+	})
+}
+
+// writeNewContributors writes a row per new contributor of each repo to
+// out, with the date of their first PR. The new contributors outside the
+// top contributors keep their rows, under the aggregated bucket.
+func writeNewContributors(out io.Writer, config Config, s *Summary, top map[string]bool, anon *Anonymiser) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"Repo", "Login", "First PR"})
+	for _, k := range config.Repos {
+		for _, c := range s.Repos[k].NewContributors {
+			w.Write([]string{k, anon.Name(topLogin(top, c.Login)), c.FirstPR.In(reportLocation(config)).Format("2006-01-02")})
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Threshold bounds the value of a metric in the latest pulse of each
+// repo. Either bound may be omitted.
+type Threshold struct {
+	Min *float64 `json:"min"`
+	Max *float64 `json:"max"`
+}
+
+// validateThresholds checks that the thresholds only reference known
+// pulse or custom metrics, and have consistent bounds.
+func validateThresholds(config Config) error {
+	known := pulseMetricValues(Pulse{})
+	for name, t := range config.Thresholds {
+		_, builtin := known[name]
+		_, custom := config.Settings.CustomMetrics[name]
+		if !builtin && !custom {
+			return fmt.Errorf("threshold on unknown metric %q", name)
+		}
+		if t.Min != nil && t.Max != nil && *t.Min > *t.Max {
+			return fmt.Errorf("threshold on %q has min %g above max %g", name, *t.Min, *t.Max)
+		}
+	}
+	return nil
+}
+
+// checkThresholds checks the latest complete pulse of a repo against the
+// thresholds, and returns a description of every breach. The partial
+// current pulse is skipped, as its values may be prorated from a few
+// days.
+func checkThresholds(config Config, k string, pulses []Pulse) ([]string, error) {
+	custom, err := customMetrics(config)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(config.Thresholds))
+	for name := range config.Thresholds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	breaches := make([]string, 0)
+	i := latestComplete(pulses)
+	if i < 0 {
+		return breaches, nil
+	}
+	values := latestMetrics(custom, pulses)
+	date := pulseDate(config, pulses[i].Start)
+
+	for _, name := range names {
+		t := config.Thresholds[name]
+		v := values[name]
+		if t.Min != nil && v < *t.Min {
+			breaches = append(breaches, fmt.Sprintf("%s: %s of %s in pulse %s is below the minimum of %g", k, name, formatFloat(config, v), date, *t.Min))
+		}
+		if t.Max != nil && v > *t.Max {
+			breaches = append(breaches, fmt.Sprintf("%s: %s of %s in pulse %s is above the maximum of %g", k, name, formatFloat(config, v), date, *t.Max))
+		}
+	}
+	return breaches, nil
+}
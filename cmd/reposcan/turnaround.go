@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"time"
+)
+
+// reviewTurnarounds returns the times from a review being requested
+// from a reviewer since the given time until their next review of the
+// PR. Requests still waiting for a review, and requests of teams, are
+// left out.
+func reviewTurnarounds(config Config, p PrEntry, since time.Time) map[string][]time.Duration {
+	turnarounds := make(map[string][]time.Duration)
+	nodes := p.Timeline.Nodes
+	for i, n := range nodes {
+		reviewer := n.ReviewRequested.RequestedReviewer.User.Login
+		if reviewer == "" {
+			continue
+		}
+		requested := n.ReviewRequested.CreatedAt
+		if requested.Before(since) {
+			continue
+		}
+		// The timeline is in chronological order
+		for _, r := range nodes[i+1:] {
+			if r.Review.Author.Login != reviewer || r.Review.SubmittedAt == nil || r.Review.SubmittedAt.Before(requested) {
+				continue
+			}
+			turnarounds[reviewer] = append(turnarounds[reviewer], latency(config, requested, *r.Review.SubmittedAt))
+			break
+		}
+	}
+	return turnarounds
+}
+
+func genReviewerTurnaround(config Config, repos map[string]*Repo, start time.Time, top map[string]bool, anon *Anonymiser) error {
+	return writeFile("reviewer-turnaround.csv", "reviewer turnaround", func(out io.Writer) error {
+		return writeReviewerTurnaround(out, config, repos, start, top, anon)
+	})
+}
+
+// writeReviewerTurnaround writes the median turnaround of each reviewer
+// over all repos to out, for the review requests since the start of the
+// graphs. Only the reviewers allowlisted for a repo are included for the
+// PRs of that repo. The reviewers outside the top contributors share a
+// row, with the median over all their reviews.
+func writeReviewerTurnaround(out io.Writer, config Config, repos map[string]*Repo, start time.Time, top map[string]bool, anon *Anonymiser) error {
+	durations := make(map[string][]time.Duration)
+	for _, k := range config.Repos {
+		rc := repoConfig(config, k)
+		for _, p := range repos[k].prs {
+			for reviewer, d := range reviewTurnarounds(rc, p, start) {
+				if allowlistedUser(rc, reviewer) {
+					login := topLogin(top, reviewer)
+					durations[login] = append(durations[login], d...)
+				}
+			}
+		}
+	}
+
+	logins := make([]string, 0, len(durations))
+	for login := range durations {
+		logins = append(logins, login)
+	}
+	sortOthersLast(logins)
+
+	w := csv.NewWriter(out)
+	w.Write([]string{"Login", "Reviews", "Turnaround Hours (Median)"})
+	for _, login := range logins {
+		w.Write([]string{
+			anon.Name(login),
+			fmt.Sprintf("%d", len(durations[login])),
+			formatFloat(config, float64(medianHours(durations[login]))),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}